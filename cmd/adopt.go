@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var adoptCmd = &cobra.Command{
+	Use:   "adopt PATH|--all",
+	Short: "Bring existing git worktrees under kosho management",
+	Long: `Adopts git worktrees which were created without kosho, such as with
+'git worktree add ../foo', so that they show up in 'kosho list' and are
+cleaned up by 'kosho prune'.
+
+By default worktrees are recorded in place and keep the name of their
+directory. With --move they are moved into the worktrees directory and
+named after their branch. Use 'kosho release' to undo an adoption.`,
+	Example: "kosho adopt ../foo\nkosho adopt --all --move",
+	Args: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		if all && len(args) > 0 {
+			return fmt.Errorf("PATH can't be used with --all")
+		}
+		if !all && len(args) != 1 {
+			return fmt.Errorf("PATH argument or --all is required")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		move, _ := cmd.Flags().GetBool("move")
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		paths := args
+		if all {
+			external, err := koshoDir.ExternalWorktrees()
+			if err != nil {
+				return err
+			}
+			if len(external) == 0 {
+				fmt.Println("No unmanaged worktrees found")
+				return nil
+			}
+			paths = nil
+			for _, gitWorktree := range external {
+				paths = append(paths, gitWorktree.Path)
+			}
+		}
+
+		failed := 0
+		for _, path := range paths {
+			fmt.Printf("Adopting %s... ", path)
+			kw, err := koshoDir.AdoptWorktree(path, move)
+			if err != nil {
+				fmt.Printf("ERROR\n%v\n", err)
+				failed++
+				continue
+			}
+			fmt.Printf("DONE (%s)\n", kw.Name())
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("failed to adopt %d worktree(s)", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	adoptCmd.Flags().Bool("all", false, "Adopt every git worktree which kosho doesn't manage")
+	adoptCmd.Flags().Bool("move", false, "Move adopted worktrees into the worktrees directory")
+	rootCmd.AddCommand(adoptCmd)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone [flags] URL [DIR]",
+	Short: "Clone a repository and set it up for kosho",
+	Long: `Clones the repository at URL into DIR and initializes its .kosho directory.
+
+Files in the template directory are copied into .kosho, without replacing
+files the repository already has. The template defaults to
+~/.config/kosho/template if it exists.
+
+With --bare, the repository is cloned as a bare repository and its default
+branch is checked out in a kosho worktree.`,
+	Example: "kosho clone git@github.com:me/project.git\nkosho clone --bare --template ~/kosho-template ../project project.git",
+	Args:    cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bare, _ := cmd.Flags().GetBool("bare")
+		template, _ := cmd.Flags().GetString("template")
+
+		if template != "" {
+			if info, err := os.Stat(template); err != nil || !info.IsDir() {
+				return fmt.Errorf("template %s is not a directory", template)
+			}
+		}
+
+		url := args[0]
+		dir := internal.CloneDir(url, bare)
+		if len(args) == 2 {
+			dir = args[1]
+		}
+
+		repoDir, err := internal.CloneRepo(url, dir, internal.CloneOptions{Bare: bare})
+		if err != nil {
+			return err
+		}
+
+		if err := internal.ApplyKoshoTemplate(repoDir, template); err != nil {
+			return err
+		}
+
+		// kosho finds the repository from the working directory
+		if err := os.Chdir(repoDir); err != nil {
+			return fmt.Errorf("failed to enter %s: %w", repoDir, err)
+		}
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		if bare {
+			branch, err := internal.DefaultBranch(koshoDir.RepoPath())
+			if err != nil {
+				return err
+			}
+			kw := internal.NewKoshoWorktree(*koshoDir, branch)
+			if err := createWorktree(kw, internal.CreateOptions{}); err != nil {
+				return err
+			}
+			if err := runHook(kw, internal.HOOK_CREATE, true); err != nil {
+				return err
+			}
+			fmt.Printf("Checked out %s in %s\n", branch, kw.WorktreePath())
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	cloneCmd.Flags().Bool("bare", false, "Clone as a bare repository and check out the default branch in a kosho worktree")
+	cloneCmd.Flags().String("template", "", "Directory to initialize .kosho from")
+	rootCmd.AddCommand(cloneCmd)
+}
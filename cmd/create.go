@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+func checkCreateArgs(cmd *cobra.Command, args []string) error {
+	// `--carry copy BRANCH` would otherwise fail with a confusing argument
+	// count
+	carryMode, _ := cmd.Flags().GetString("carry")
+	if err := checkCarryMode(carryMode, args); err != nil {
+		return err
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create [flags] BRANCH",
+	Short: "Creates a Git worktree checked out to BRANCH without running a command",
+	Long: `Creates a worktree for BRANCH and runs the create hook, like 'kosho run' does
+before running its command. If BRANCH doesn't exist, it's created at --from,
+which defaults to the repository's HEAD. Tags and commit hashes, or any ref
+with --detach, are checked out at a detached HEAD instead, as in 'kosho run'.
+
+--carry copies the uncommitted changes of the current checkout into the new
+worktree, and --carry=move also removes them from the checkout, as in 'kosho
+run'. The mode must be attached with '='.
+
+With --print-path, progress is written to stderr and only the worktree's path
+is written to stdout, for use in scripts.`,
+	Example:           "kosho create bugfix\nkosho create --from origin/main --name api feature/api\ncd \"$(kosho create --print-path bugfix)\"",
+	Args:              checkCreateArgs,
+	ValidArgsFunction: internal.BranchCompletion,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		branch := args[0]
+
+		from, _ := cmd.Flags().GetString("from")
+		name, _ := cmd.Flags().GetString("name")
+		noHooks, _ := cmd.Flags().GetBool("no-hooks")
+		printPath, _ := cmd.Flags().GetBool("print-path")
+
+		sparseList, _ := cmd.Flags().GetString("sparse")
+		sparse, err := internal.ParseSparsePaths(sparseList)
+		if err != nil {
+			return err
+		}
+
+		carryMode, _ := cmd.Flags().GetString("carry")
+
+		// hooks write to stdout too, so redirect everything but the path
+		stdout := os.Stdout
+		if printPath {
+			os.Stdout = os.Stderr
+			defer func() { os.Stdout = stdout }()
+		}
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		detach, _ := cmd.Flags().GetBool("detach")
+		kw, err := resolveWorktree(koshoDir, branch, name, detach)
+		if err != nil {
+			return err
+		}
+		if kw.State != "" {
+			return fmt.Errorf("%s already has worktree '%s' at %s", branch, kw.Name(), kw.WorktreePath())
+		}
+		reportWorktreeName(kw, branch, name)
+
+		var carry *internal.CarriedChanges
+		if carryMode != "" {
+			if carry, err = captureCarriedChanges(); err != nil {
+				return err
+			}
+		}
+
+		opts := internal.CreateOptions{Sparse: sparse, From: from, Carry: carry}
+		if err := newWorktree(kw, opts, noHooks); err != nil {
+			return err
+		}
+
+		if carry != nil && carryMode == CARRY_MOVE {
+			if err := discardCarriedChanges(carry); err != nil {
+				return err
+			}
+		}
+
+		if printPath {
+			fmt.Fprintln(stdout, kw.WorktreePath())
+		} else {
+			fmt.Printf("%s is checked out in %s\n", branch, kw.WorktreePath())
+		}
+		return nil
+	},
+}
+
+func init() {
+	createCmd.Flags().String("from", "", "Create BRANCH at this ref if it doesn't exist, defaults to HEAD")
+	createCmd.Flags().String("name", "", "Name of the worktree directory, defaults to a slug of BRANCH")
+	createCmd.Flags().String("sparse", "", "Comma separated directories to check out (cone mode sparse checkout)")
+	createCmd.Flags().Bool("detach", false, "Check out BRANCH, which may be any ref, at a detached HEAD rather than as a branch")
+	createCmd.Flags().Bool("no-hooks", false, "Don't run the create hook")
+	createCmd.Flags().Bool("print-path", false, "Only print the path of the new worktree to stdout")
+	createCmd.Flags().String("carry", "", "Carry the current checkout's uncommitted changes into the new worktree: copy, or move to also remove them from the checkout")
+	createCmd.Flags().Lookup("carry").NoOptDefVal = CARRY_COPY
+	rootCmd.AddCommand(createCmd)
+}
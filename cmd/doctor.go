@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose and repair kosho and worktree state",
+	Long: `Checks for drift between kosho and git:
+
+- directories in the worktrees directory which aren't registered with git
+- worktrees registered with git whose directory is missing
+- worktrees and pooled worktrees whose links to and from the repository don't
+  resolve to each other, e.g. after moving the repository
+- worktrees linked to the repository by absolute paths
+- worktrees which have a different branch checked out than they were created for
+- a missing or outdated .kosho/.gitignore
+- hooks which aren't executable
+
+With --fix, kosho repairs every issue it can and explains how to resolve the rest.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fix, _ := cmd.Flags().GetBool("fix")
+
+		// open the kosho directory without setting it up, so that doctor sees
+		// what other commands would repair silently
+		koshoDir, err := internal.OpenKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		// fixing some issues, like broken links to the repository, reveals
+		// others, so diagnose again until nothing more can be fixed
+		seen := make(map[string]bool)
+		unresolved := 0
+		for {
+			issues, err := koshoDir.Diagnose()
+			if err != nil {
+				return err
+			}
+
+			fixed := 0
+			for _, issue := range issues {
+				if seen[issue.Description] {
+					continue
+				}
+				seen[issue.Description] = true
+
+				fmt.Printf("- %s\n", issue.Description)
+				if !issue.Fixable() {
+					fmt.Printf("  %s\n", issue.Hint)
+					unresolved++
+					continue
+				}
+				if !fix {
+					unresolved++
+					continue
+				}
+
+				fmt.Printf("  fixing... ")
+				if err := issue.Fix(); err != nil {
+					fmt.Printf("ERROR\n%v\n", err)
+					unresolved++
+					continue
+				}
+				fmt.Printf("DONE\n")
+				fixed++
+			}
+
+			if fixed == 0 {
+				break
+			}
+		}
+
+		if len(seen) == 0 {
+			fmt.Println("No issues found")
+			return nil
+		}
+		if unresolved > 0 {
+			if !fix {
+				return fmt.Errorf("found %d issue(s), run `kosho doctor --fix` to repair them", unresolved)
+			}
+			return fmt.Errorf("%d issue(s) need to be resolved by hand", unresolved)
+		}
+		return nil
+	},
+}
+
+func init() {
+	doctorCmd.Flags().Bool("fix", false, "Repair the issues which can be fixed automatically")
+	rootCmd.AddCommand(doctorCmd)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var forkCmd = &cobra.Command{
+	Use:   "fork [flags] SOURCE NEW [COMMAND [args...]]",
+	Short: "Branch a new worktree from another worktree's current state",
+	Long: `Creates branch NEW at the HEAD of the worktree for branch SOURCE, checks it
+out in a new worktree and copies SOURCE's staged and unstaged changes into
+it. Untracked files are copied too with --untracked. SOURCE is left
+untouched.
+
+If COMMAND is given, it's run in the new worktree like 'kosho run'. Flags
+must come before SOURCE.`,
+	Example:           "kosho fork agent-1 agent-1-alt claude\nkosho fork --untracked bugfix bugfix-2",
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: internal.BranchCompletion,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sourceBranch, newBranch, rest := args[0], args[1], args[2:]
+		untracked, _ := cmd.Flags().GetBool("untracked")
+		name, _ := cmd.Flags().GetString("name")
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		source, err := koshoDir.FindBranchWorktree(sourceBranch)
+		if err != nil {
+			return err
+		}
+		kw, err := koshoDir.ResolveWorktree(newBranch, name)
+		if err != nil {
+			return err
+		}
+		if kw.State != "" {
+			return fmt.Errorf("branch %s already has worktree '%s'", newBranch, kw.Name())
+		}
+
+		fmt.Printf("Forking worktree '%s' into '%s'... ", source.Name(), kw.Name())
+		results, err := kw.Fork(source, untracked)
+		if err != nil {
+			fmt.Printf("ERROR\n")
+			return fmt.Errorf("failed to fork worktree: %w", err)
+		}
+		fmt.Printf("DONE\n")
+
+		for _, result := range results {
+			if result.Clone != nil {
+				fmt.Println(result.String())
+			}
+		}
+
+		if err := runHook(kw, internal.HOOK_CREATE, true); err != nil {
+			return err
+		}
+
+		if len(rest) == 0 {
+			fmt.Printf("Branch %s is checked out in %s\n", newBranch, kw.WorktreePath())
+			return nil
+		}
+
+		if err := runHook(kw, internal.HOOK_RUN, true, fmt.Sprintf("KOSHO_CMD=%q", rest[0])); err != nil {
+			return err
+		}
+		return kw.RunCommand("", rest)
+	},
+}
+
+func init() {
+	// stop parsing flags at SOURCE so that flags are passed to the command
+	forkCmd.Flags().SetInterspersed(false)
+	forkCmd.Flags().Bool("untracked", false, "Copy untracked files which aren't ignored too")
+	forkCmd.Flags().String("name", "", "Name of the new worktree directory, defaults to a slug of NEW")
+	rootCmd.AddCommand(forkCmd)
+}
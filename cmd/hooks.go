@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+)
+
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "Manage kosho hooks",
+	Long: `Inspect, test, enable and disable the hooks stored in .kosho/hooks and
+the user-global hooks stored in ~/.config/kosho/hooks.`,
+}
+
+var hooksListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all hooks and whether they are enabled",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		tbl := table.New("HOOK", "SCOPE", "STATE", "PATH")
+		for _, scope := range internal.HookScopes {
+			for _, hook := range internal.KoshoHookTypes {
+				state, err := koshoDir.HookState(scope, hook)
+				if err != nil {
+					return err
+				}
+
+				path, err := koshoDir.ScopedHookPath(scope, hook)
+				if err != nil {
+					return err
+				}
+				switch state {
+				case internal.HOOK_STATE_DISABLED:
+					path += internal.HOOK_SAMPLE_SUFFIX
+				case internal.HOOK_STATE_MISSING:
+					path = ""
+				}
+
+				tbl.AddRow(hook, scope, state, path)
+			}
+		}
+
+		tbl.Print()
+
+		return nil
+	},
+}
+
+var hooksRunCmd = &cobra.Command{
+	Use:   "run HOOK NAME [COMMAND]",
+	Short: "Run HOOK in the worktree NAME",
+	Long: `Run HOOK in the existing worktree NAME, or the worktree of the branch NAME,
+with the same environment kosho provides during normal operation. When
+testing the run hook, COMMAND is passed to the hook as $KOSHO_CMD. Both the
+user-global and repository versions of the hook are run.`,
+	Example:           "kosho hooks run create bugfix",
+	Args:              cobra.RangeArgs(2, 3),
+	ValidArgsFunction: internal.HooksRunCompletion,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hook, err := internal.ParseKoshoHook(args[0])
+		if err != nil {
+			return err
+		}
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		enabled := false
+		for _, scope := range koshoDir.ActiveHookScopes() {
+			state, err := koshoDir.HookState(scope, hook)
+			if err != nil {
+				return err
+			}
+			enabled = enabled || state == internal.HOOK_STATE_ENABLED
+		}
+		if !enabled {
+			return fmt.Errorf("hook %s is not enabled", hook)
+		}
+
+		kw, err := koshoDir.FindNamedWorktree(args[1])
+		if err != nil {
+			return err
+		}
+
+		var extraEnv []string
+		if len(args) == 3 {
+			extraEnv = append(extraEnv, fmt.Sprintf("KOSHO_CMD=%q", args[2]))
+		}
+
+		return internal.RunKoshoHook(kw, hook, extraEnv...)
+	},
+}
+
+var hooksEnableCmd = &cobra.Command{
+	Use:               "enable HOOK",
+	Short:             "Enable HOOK by renaming HOOK.sample to HOOK",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: internal.HookNameCompletion,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hook, err := internal.ParseKoshoHook(args[0])
+		if err != nil {
+			return err
+		}
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		scope := hookScope(cmd)
+		if err := koshoDir.EnableHook(scope, hook); err != nil {
+			return err
+		}
+
+		fmt.Printf("Enabled %s hook '%s'\n", scope, hook)
+		return nil
+	},
+}
+
+var hooksDisableCmd = &cobra.Command{
+	Use:   "disable HOOK",
+	Short: "Disable HOOK by renaming HOOK to HOOK.sample",
+	Long: `Disable HOOK by renaming HOOK to HOOK.sample. Note that .sample files are
+ignored by git and are overwritten by 'kosho hooks update-samples'.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: internal.HookNameCompletion,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hook, err := internal.ParseKoshoHook(args[0])
+		if err != nil {
+			return err
+		}
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		scope := hookScope(cmd)
+		if err := koshoDir.DisableHook(scope, hook); err != nil {
+			return err
+		}
+
+		fmt.Printf("Disabled %s hook '%s'\n", scope, hook)
+		return nil
+	},
+}
+
+var hooksUpdateSamplesCmd = &cobra.Command{
+	Use:   "update-samples",
+	Short: "Refresh the sample hooks in .kosho/hooks",
+	Long: `Overwrite the .sample hooks in .kosho/hooks with the samples bundled with
+this version of kosho, and remove samples for hooks which no longer exist.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		if err := koshoDir.UpdateHookSamples(); err != nil {
+			return fmt.Errorf("failed to update sample hooks: %w", err)
+		}
+
+		fmt.Println("Updated sample hooks")
+		return nil
+	},
+}
+
+func hookScope(cmd *cobra.Command) internal.HookScope {
+	if global, _ := cmd.Flags().GetBool("global"); global {
+		return internal.HOOK_SCOPE_GLOBAL
+	}
+	return internal.HOOK_SCOPE_REPO
+}
+
+func init() {
+	hooksEnableCmd.Flags().Bool("global", false, "Enable the user-global hook instead of the repository hook")
+	hooksDisableCmd.Flags().Bool("global", false, "Disable the user-global hook instead of the repository hook")
+
+	hooksCmd.AddCommand(hooksListCmd)
+	hooksCmd.AddCommand(hooksRunCmd)
+	hooksCmd.AddCommand(hooksEnableCmd)
+	hooksCmd.AddCommand(hooksDisableCmd)
+	hooksCmd.AddCommand(hooksUpdateSamplesCmd)
+	rootCmd.AddCommand(hooksCmd)
+}
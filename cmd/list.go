@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/carlsverre/kosho/internal"
 
@@ -12,7 +13,13 @@ import (
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all kosho worktrees",
-	Long:  `List all kosho worktrees and their current git status.`,
+	Long: `List all kosho worktrees and their current git status.
+
+Worktrees are read from git's worktree registry. Worktrees which are locked,
+prunable (missing from disk) or stored outside the worktrees directory have
+their state appended to STATUS. Directories in the worktrees directory which
+aren't registered with git are listed as orphans. Worktrees with a detached
+HEAD show the tag or abbreviated commit they are at in REF.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		koshoDir, err := internal.LoadKoshoDir()
 		if err != nil {
@@ -29,8 +36,31 @@ var listCmd = &cobra.Command{
 			return nil
 		}
 
-		tbl := table.New("NAME", "UPSTREAM", "REF", "STATUS")
-		for _, kw := range worktrees {
+		// only show the sparse column if some worktree uses it
+		sparsePaths := make([][]string, len(worktrees))
+		hasSparse := false
+		for i, kw := range worktrees {
+			sparsePaths[i], _ = kw.SparsePaths()
+			hasSparse = hasSparse || len(sparsePaths[i]) > 0
+		}
+
+		headers := []any{"NAME", "UPSTREAM", "REF", "STATUS"}
+		if hasSparse {
+			headers = append(headers, "SPARSE")
+		}
+
+		tbl := table.New(headers...)
+		for i, kw := range worktrees {
+			// orphaned and prunable worktrees have no working git checkout
+			if kw.State == internal.WORKTREE_ORPHAN || kw.State == internal.WORKTREE_PRUNABLE {
+				row := []any{kw.Name(), "", "", worktreeState(kw)}
+				if hasSparse {
+					row = append(row, "")
+				}
+				tbl.AddRow(row...)
+				continue
+			}
+
 			upstream, err := kw.GetUpstream()
 			if err != nil {
 				upstream = "unknown"
@@ -39,14 +69,24 @@ var listCmd = &cobra.Command{
 			gitRef, err := kw.GitBranch()
 			if err != nil {
 				gitRef = "detached"
+				if head, err := kw.DescribeHead(); err == nil {
+					gitRef = "detached at " + head
+				}
 			}
 
 			status, err := kw.Status()
 			if err != nil {
 				status = "error"
 			}
+			if kw.State != internal.WORKTREE_OK {
+				status += " " + worktreeState(kw)
+			}
 
-			tbl.AddRow(kw.Name(), upstream, gitRef, status)
+			row := []any{kw.Name(), upstream, gitRef, status}
+			if hasSparse {
+				row = append(row, strings.Join(sparsePaths[i], ","))
+			}
+			tbl.AddRow(row...)
 		}
 
 		tbl.Print()
@@ -55,6 +95,14 @@ var listCmd = &cobra.Command{
 	},
 }
 
+// worktreeState describes a worktree's state and the reason for it
+func worktreeState(kw internal.KoshoWorktree) string {
+	if kw.StateReason == "" {
+		return string(kw.State)
+	}
+	return fmt.Sprintf("%s: %s", kw.State, kw.StateReason)
+}
+
 func init() {
 	rootCmd.AddCommand(listCmd)
 }
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateLocationCmd = &cobra.Command{
+	Use:   "migrate-location",
+	Short: "Move existing worktrees into the configured worktree root",
+	Long: `Move kosho worktrees which are stored outside the configured worktrees
+directory into it using 'git worktree move'. Run this after changing
+worktree_root in .kosho/config.json or ~/.config/kosho/config.json.
+
+Pooled worktrees are not moved, run 'kosho pool drain' before migrating.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		misplaced, err := koshoDir.MisplacedWorktrees()
+		if err != nil {
+			return err
+		}
+
+		if len(misplaced) == 0 {
+			fmt.Printf("All worktrees are stored in %s\n", koshoDir.WorktreesDir())
+			return nil
+		}
+
+		failed := 0
+		for _, m := range misplaced {
+			if dryRun {
+				fmt.Printf("Would move '%s' from %s to %s\n", m.Worktree.Name(), m.Path, m.Worktree.WorktreePath())
+				continue
+			}
+
+			fmt.Printf("Moving '%s' to %s... ", m.Worktree.Name(), m.Worktree.WorktreePath())
+			if err := m.Worktree.MoveFrom(m.Path); err != nil {
+				fmt.Printf("ERROR\n%v\n", err)
+				failed++
+				continue
+			}
+			fmt.Printf("DONE\n")
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("failed to move %d worktrees", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	migrateLocationCmd.Flags().Bool("dry-run", false, "Show which worktrees would be moved without moving them")
+	rootCmd.AddCommand(migrateLocationCmd)
+}
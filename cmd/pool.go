@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+)
+
+var poolCmd = &cobra.Command{
+	Use:   "pool",
+	Short: "Manage the warm pool of pre-created worktrees",
+	Long: `Kosho can keep a pool of worktrees which have already been created and
+had their create hook run. When 'kosho run' needs a new worktree, it claims
+one from the pool instead of creating it from scratch.`,
+}
+
+var poolFillCmd = &cobra.Command{
+	Use:     "fill",
+	Short:   "Create pooled worktrees until the pool contains --size worktrees",
+	Example: "kosho pool fill --size 4 --from main",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		size, _ := cmd.Flags().GetInt("size")
+		from, _ := cmd.Flags().GetString("from")
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		pool, err := koshoDir.ListPool()
+		if err != nil {
+			return fmt.Errorf("failed to list pool: %w", err)
+		}
+
+		for i := len(pool); i < size; i++ {
+			fmt.Printf("Creating pooled worktree at '%s'... ", from)
+			kw, err := koshoDir.CreatePooledWorktree(from)
+			if err != nil {
+				fmt.Printf("ERROR\n")
+				return err
+			}
+			fmt.Printf("DONE\n")
+
+			if err := runHook(kw, internal.HOOK_CREATE, true, "KOSHO_POOLED=1"); err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Pool contains %d worktrees\n", max(size, len(pool)))
+		return nil
+	},
+}
+
+var poolListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the worktrees in the pool",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		pool, err := koshoDir.ListPool()
+		if err != nil {
+			return fmt.Errorf("failed to list pool: %w", err)
+		}
+
+		if len(pool) == 0 {
+			fmt.Println("The pool is empty")
+			return nil
+		}
+
+		tbl := table.New("ID", "COMMIT", "PATH")
+		for _, kw := range pool {
+			commit, err := kw.ShortCommit()
+			if err != nil {
+				commit = "unknown"
+			}
+			tbl.AddRow(kw.Name(), commit, kw.WorktreePath())
+		}
+
+		tbl.Print()
+
+		return nil
+	},
+}
+
+var poolDrainCmd = &cobra.Command{
+	Use:   "drain",
+	Short: "Remove every worktree in the pool",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		pool, err := koshoDir.ListPool()
+		if err != nil {
+			return fmt.Errorf("failed to list pool: %w", err)
+		}
+
+		removed := 0
+		for _, kw := range pool {
+			unlock, locked, err := kw.LockPooledWorktree()
+			if err != nil {
+				return err
+			}
+			if !locked {
+				fmt.Printf("Skipping pooled worktree %s which is being claimed\n", kw.Name())
+				continue
+			}
+			err = kw.Remove(true)
+			unlock()
+			if err != nil {
+				return fmt.Errorf("failed to remove pooled worktree %s: %w", kw.Name(), err)
+			}
+			removed++
+		}
+
+		fmt.Printf("Removed %d pooled worktrees\n", removed)
+		return nil
+	},
+}
+
+func init() {
+	poolFillCmd.Flags().Int("size", 1, "Number of worktrees to keep in the pool")
+	poolFillCmd.Flags().String("from", "HEAD", "Ref to check out in pooled worktrees")
+
+	poolCmd.AddCommand(poolFillCmd)
+	poolCmd.AddCommand(poolListCmd)
+	poolCmd.AddCommand(poolDrainCmd)
+	rootCmd.AddCommand(poolCmd)
+}
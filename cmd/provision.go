@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var provisionCmd = &cobra.Command{
+	Use:   "provision NAME",
+	Short: "Re-apply the provision rules to the worktree NAME",
+	Long: `Copy, symlink or render the files listed in the provision section of
+.kosho/config.json from the main checkout into the existing worktree NAME, or
+the worktree of the branch NAME, replacing any previously provisioned files
+and directories. Only files ignored by git can be provisioned.`,
+	Example:           "kosho provision bugfix",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: internal.WorktreeCompletion,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		kw, err := koshoDir.FindNamedWorktree(args[0])
+		if err != nil {
+			return err
+		}
+
+		rules := koshoDir.Config().Provision
+		if len(rules) == 0 {
+			fmt.Printf("No provision rules found in %s\n", koshoDir.ConfigPath())
+			return nil
+		}
+
+		results, err := kw.Provision()
+		for _, result := range results {
+			fmt.Println(result.String())
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(provisionCmd)
+}
@@ -13,6 +13,13 @@ import (
 var pruneCmd = &cobra.Command{
 	Use:   "prune",
 	Short: "Cleanup clean worktrees and dangling worktree references",
+	Long: `Removes clean worktrees and runs git worktree prune to remove worktrees
+which are missing from disk. Locked worktrees and orphaned directories which
+aren't registered with git are left alone, as are worktrees in which kosho is
+running a command.
+
+Detached worktrees created at a tag or commit are removed once they are clean,
+unless commits have been made in them.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		koshoDir, err := internal.LoadKoshoDir()
 		if err != nil {
@@ -26,11 +33,52 @@ var pruneCmd = &cobra.Command{
 
 		// iterate through worktrees, removing any clean worktrees
 		for _, worktree := range worktrees {
+			switch worktree.State {
+			case internal.WORKTREE_ORPHAN:
+				fmt.Printf("Skipping orphaned directory %s\n", worktree.WorktreePath())
+				continue
+			case internal.WORKTREE_LOCKED:
+				fmt.Printf("Skipping locked worktree '%s'\n", worktree.Name())
+				continue
+			case internal.WORKTREE_PRUNABLE:
+				// git worktree prune removes the registration below
+				if err := worktree.RemoveMetadata(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if pid := worktree.TmpOwner(); pid != 0 {
+				fmt.Printf("Skipping temporary worktree '%s' in use by pid %d\n", worktree.Name(), pid)
+				continue
+			}
+
+			pids, err := worktree.RunningPids()
+			if err != nil {
+				return err
+			}
+			if len(pids) > 0 {
+				fmt.Printf("Skipping worktree '%s' in use by pid %d\n", worktree.Name(), pids[0])
+				continue
+			}
+
+			disposable, err := worktree.IsDisposable()
+			if err != nil {
+				return fmt.Errorf("failed to check worktree '%s': %w", worktree.Name(), err)
+			}
+			if !disposable && worktree.DetachedRef != "" {
+				// the commits aren't on any branch, so removing it would lose them
+				fmt.Printf("Skipping detached worktree '%s' which has new commits\n", worktree.Name())
+				continue
+			}
+
 			clean, err := worktree.IsClean()
 			if err != nil {
 				return fmt.Errorf("failed to check worktree status: %w", err)
 			}
-			if clean {
+			if !clean && disposable {
+				fmt.Printf("Skipping detached worktree '%s' which has uncommitted changes\n", worktree.Name())
+			} else if clean {
 				err := worktree.Remove(false)
 				if err != nil {
 					return fmt.Errorf("failed to remove worktree %s: %w", worktree.Name(), err)
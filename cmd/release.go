@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var releaseCmd = &cobra.Command{
+	Use:   "release NAME [PATH]",
+	Short: "Stop managing a worktree without removing it",
+	Long: `Releases a worktree from kosho management, reversing 'kosho adopt'. The
+worktree and its branch are left as they are.
+
+Worktrees stored in the worktrees directory are moved to PATH, or back to
+where they were adopted from if PATH is omitted.`,
+	Args:              cobra.RangeArgs(1, 2),
+	ValidArgsFunction: internal.WorktreeCompletion,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dest := ""
+		if len(args) == 2 {
+			dest = args[1]
+		}
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		kw, err := koshoDir.FindWorktree(args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Releasing worktree '%s'... ", kw.Name())
+		path, err := kw.Release(dest)
+		if err != nil {
+			fmt.Printf("ERROR\n")
+			return fmt.Errorf("failed to release worktree: %w", err)
+		}
+		fmt.Printf("DONE\n")
+		fmt.Printf("Worktree is now unmanaged at %s\n", path)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(releaseCmd)
+}
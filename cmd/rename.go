@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var renameCmd = &cobra.Command{
+	Use:   "rename [flags] OLD NEW",
+	Short: "Rename a worktree's branch and move the worktree to match",
+	Long: `Renames branch OLD to NEW with 'git branch -m' and moves its worktree to the
+name for NEW with 'git worktree move'. Untracked files and kosho's metadata
+move with the worktree.
+
+Refuses if NEW already exists, or if a command started by 'kosho run' is
+still running in the worktree.`,
+	Example:           "kosho rename agent-3 fix/login-redirect",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: internal.BranchCompletion,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldBranch, newBranch := args[0], args[1]
+		name, _ := cmd.Flags().GetString("name")
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		kw, err := koshoDir.FindBranchWorktree(oldBranch)
+		if err != nil {
+			return err
+		}
+
+		// keep the name if it already matches the new branch
+		newName := kw.Name()
+		if name != "" || internal.DefaultWorktreeName(newBranch) != kw.Name() {
+			target, err := koshoDir.ResolveWorktree(newBranch, name)
+			if err != nil {
+				return err
+			}
+			if target.State != "" {
+				return fmt.Errorf("branch %s already has worktree '%s'", newBranch, target.Name())
+			}
+			newName = target.Name()
+		}
+
+		fmt.Printf("Renaming worktree '%s' to '%s'... ", kw.Name(), newName)
+		renamed, err := kw.Rename(newBranch, newName)
+		if err != nil {
+			fmt.Printf("ERROR\n")
+			return fmt.Errorf("failed to rename worktree: %w", err)
+		}
+		fmt.Printf("DONE\n")
+		fmt.Printf("Branch %s is checked out in %s\n", newBranch, renamed.WorktreePath())
+		return nil
+	},
+}
+
+func init() {
+	renameCmd.Flags().String("name", "", "Name of the worktree directory, defaults to a slug of NEW")
+	rootCmd.AddCommand(renameCmd)
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
 )
 
@@ -13,6 +15,16 @@ launch tools within them for isolated development environments.`,
 	SilenceErrors: true,
 }
 
+// ExitCodeError makes kosho exit with the exit code of a command it ran,
+// without printing an error
+type ExitCodeError struct {
+	Code int
+}
+
+func (e *ExitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
 func Execute() error {
 	return rootCmd.Execute()
 }
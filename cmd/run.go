@@ -2,30 +2,28 @@ package cmd
 
 import (
 	"fmt"
-	"strings"
+	"os"
+	"path/filepath"
+	"slices"
 
 	"github.com/carlsverre/kosho/internal"
 
 	"github.com/spf13/cobra"
 )
 
-func hasHelp(args []string) bool {
-	for _, arg := range args {
-		if !strings.HasPrefix(arg, "-") {
-			break
-		}
-		if arg == "--help" || arg == "-h" {
-			return true
-		}
-	}
-	return false
-}
+const (
+	CARRY_COPY = "copy"
+	CARRY_MOVE = "move"
+)
 
 func checkRunArgs(cmd *cobra.Command, args []string) error {
-	if hasHelp(args) {
+	// --auto generates BRANCH
+	if auto, _ := cmd.Flags().GetBool("auto"); auto {
+		if len(args) == 0 {
+			return fmt.Errorf("command is required")
+		}
 		return nil
 	}
-
 	if len(args) == 0 {
 		return fmt.Errorf("BRANCH argument is required")
 	}
@@ -36,44 +34,110 @@ func checkRunArgs(cmd *cobra.Command, args []string) error {
 }
 
 var runCmd = &cobra.Command{
-	Use:   "run BRANCH COMMAND [args...]",
+	Use:   "run [flags] (BRANCH | --auto) COMMAND [args...]",
 	Short: "Runs COMMAND in a Git worktree checked out to BRANCH",
 	Long: `Runs COMMAND in a Git worktree located at .kosho/BRANCH.
-If the worktree or branch doesn't exist, it will be created. Any
-additional arguments and flags will be passed through as-is to the
-command.`,
-	Example:            "kosho run bugfix pnpm build",
-	Args:               checkRunArgs,
-	ValidArgsFunction:  internal.RunCompletion,
-	DisableFlagParsing: true,
+If the worktree or branch doesn't exist, it will be created unless
+--no-create is set. New worktrees are named after a slug of BRANCH, or
+--name. If another branch's worktree already has that slug, a numeric suffix
+is added. Flags must come before BRANCH. Any arguments and flags after BRANCH
+will be passed through as-is to the command.
+
+Tags and commit hashes which aren't also branch names are checked out at a
+detached HEAD rather than creating a branch named after them. --detach does
+the same for any ref, such as origin/main.
+
+--auto generates BRANCH from the branch_template config, which defaults to
+{user}/{date}-{words}, and prints it on stderr.
+
+--carry copies the staged, unstaged and untracked changes of the current
+checkout into the new worktree, whose branch starts at the checkout's HEAD.
+--carry=move also removes them from the checkout, but only once the worktree
+has been created and its hooks have succeeded, and only if the checkout
+hasn't changed since. The mode must be attached with '=', as in --carry=move.`,
+	Example:           "kosho run bugfix pnpm build\nkosho run --sparse services/api,libs/common --dir services/api bugfix make\nkosho run --name login feature/login-page claude\nkosho run --carry=move feature/login-page claude\nkosho run v1.2.3 make test\nkosho run --detach origin/main make test\nkosho run --auto claude",
+	Args:              checkRunArgs,
+	ValidArgsFunction: internal.RunCompletion,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Handle --help explicitly when flag parsing is disabled
-		if hasHelp(args) {
-			return cmd.Help()
+		auto, _ := cmd.Flags().GetBool("auto")
+		detach, _ := cmd.Flags().GetBool("detach")
+		noCreate, _ := cmd.Flags().GetBool("no-create")
+		if auto && (detach || noCreate) {
+			return fmt.Errorf("--auto can't be combined with --detach or --no-create")
+		}
+
+		var branch string
+		rest := args
+		if !auto {
+			branch, rest = args[0], args[1:]
 		}
 
-		branch, rest := args[0], args[1:]
+		sparseList, _ := cmd.Flags().GetString("sparse")
+		sparse, err := internal.ParseSparsePaths(sparseList)
+		if err != nil {
+			return err
+		}
+
+		dir, _ := cmd.Flags().GetString("dir")
+		if dir != "" && !filepath.IsLocal(dir) {
+			return fmt.Errorf("--dir must be relative to the worktree root: %s", dir)
+		}
+
+		carryMode, _ := cmd.Flags().GetString("carry")
+		if err := checkCarryMode(carryMode, args); err != nil {
+			return err
+		}
 
 		koshoDir, err := internal.LoadKoshoDir()
 		if err != nil {
 			return fmt.Errorf("failed to load Kosho dir: %w", err)
 		}
 
-		kw := internal.NewKoshoWorktree(*koshoDir, branch)
+		if auto {
+			if branch, err = koshoDir.GenerateBranchName(); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Generated branch %s\n", branch)
+		}
+
+		name, _ := cmd.Flags().GetString("name")
+		kw, err := resolveWorktree(koshoDir, branch, name, detach)
+		if err != nil {
+			return err
+		}
 
 		createdWorktree := false
+		var carry *internal.CarriedChanges
 
 		// Check if worktree already exists
 		if exists, err := kw.Exists(); !exists {
-			if err := createWorktree(kw); err != nil {
-				return err
+			if noCreate {
+				return fmt.Errorf("branch %s has no worktree, create it with `kosho create %s`", branch, branch)
+			}
+			reportWorktreeName(kw, branch, name)
+
+			if carryMode != "" {
+				if carry, err = captureCarriedChanges(); err != nil {
+					return err
+				}
 			}
-			if err := runHook(kw, internal.HOOK_CREATE, true); err != nil {
+
+			if err := newWorktree(kw, internal.CreateOptions{Sparse: sparse, Carry: carry}, false); err != nil {
 				return err
 			}
 			createdWorktree = true
 		} else if err != nil {
 			return fmt.Errorf("failed to check worktree path: %w", err)
+		} else if carryMode != "" {
+			return fmt.Errorf("worktree '%s' already exists, --carry only applies when creating a worktree", kw.Name())
+		} else if len(sparse) > 0 {
+			current, err := kw.SparsePaths()
+			if err != nil {
+				return err
+			}
+			if !slices.Equal(current, sparse) {
+				return fmt.Errorf("worktree '%s' already exists, use `kosho sparse %s add` to change its sparse checkout", kw.Name(), branch)
+			}
 		}
 
 		// Run the run hook if it exists
@@ -81,12 +145,22 @@ command.`,
 			return err
 		}
 
-		return kw.RunCommand(rest)
+		if carry != nil && carryMode == CARRY_MOVE {
+			if err := discardCarriedChanges(carry); err != nil {
+				return err
+			}
+		}
+
+		return kw.RunCommand(dir, rest)
 	},
 }
 
 func runHook(kw *internal.KoshoWorktree, hook internal.KoshoHook, deleteWorktreeOnFailure bool, extraEnv ...string) error {
-	if err := internal.RunKoshoHook(kw, hook, extraEnv...); err != nil {
+	return handleHookFailure(kw, hook, deleteWorktreeOnFailure, internal.RunKoshoHook(kw, hook, extraEnv...))
+}
+
+func handleHookFailure(kw *internal.KoshoWorktree, hook internal.KoshoHook, deleteWorktreeOnFailure bool, err error) error {
+	if err != nil {
 		fmt.Printf("Failed to run hook `%s`\n", hook)
 		if deleteWorktreeOnFailure {
 			fmt.Printf("cleaning up worktree '%s'... ", kw.Name())
@@ -101,20 +175,151 @@ func runHook(kw *internal.KoshoWorktree, hook internal.KoshoHook, deleteWorktree
 	return nil
 }
 
-func createWorktree(kw *internal.KoshoWorktree) error {
+// newWorktree creates the worktree, claiming a pooled worktree if the
+// options allow it, and runs the create hook unless noHooks is set. The
+// worktree is removed if the hook fails.
+func newWorktree(kw *internal.KoshoWorktree, opts internal.CreateOptions, noHooks bool) error {
+	// pooled worktrees have a full checkout at the pool's ref with the create
+	// hook already run
+	if len(opts.Sparse) == 0 && opts.From == "" && opts.Carry == nil && kw.DetachedRef == "" && !noHooks {
+		claimed, err := claimPooledWorktree(kw)
+		if err != nil || claimed {
+			return err
+		}
+	}
+
+	if err := createWorktree(kw, opts); err != nil {
+		return err
+	}
+	if noHooks {
+		return nil
+	}
+	return runHook(kw, internal.HOOK_CREATE, true)
+}
+
+// resolveWorktree returns the worktree for branch, or a worktree with a
+// detached HEAD at it if detach is set or it's a tag or commit hash rather
+// than a branch
+func resolveWorktree(koshoDir *internal.KoshoDir, branch, name string, detach bool) (*internal.KoshoWorktree, error) {
+	if !detach && !internal.IsDetachedRef(koshoDir.RepoPath(), branch) {
+		return koshoDir.ResolveWorktree(branch, name)
+	}
+
+	kw, err := koshoDir.ResolveDetachedWorktree(branch, name)
+	if err != nil {
+		return nil, err
+	}
+	if kw.State == "" {
+		if _, err := internal.ResolveCommit(koshoDir.RepoPath(), branch); err != nil {
+			return nil, err
+		}
+	}
+	return kw, nil
+}
+
+// reportWorktreeName explains why a new worktree isn't named after the
+// default slug of its branch
+func reportWorktreeName(kw *internal.KoshoWorktree, branch, name string) {
+	if name == "" && kw.Name() != internal.DefaultWorktreeName(branch) {
+		fmt.Printf("Worktree name '%s' belongs to another branch, using '%s'\n", internal.DefaultWorktreeName(branch), kw.Name())
+	}
+}
+
+func createWorktree(kw *internal.KoshoWorktree, opts internal.CreateOptions) error {
 	fmt.Printf("Creating worktree '%s'... ", kw.Name())
 
 	// Create the worktree
-	err := kw.CreateWorktree()
+	results, err := kw.CreateWorktree(opts)
 	if err != nil {
 		fmt.Printf("ERROR\n")
 		return fmt.Errorf("failed to create worktree: %w", err)
 	}
 
+	fmt.Printf("DONE\n")
+
+	// Report how heavy directories were cloned
+	for _, result := range results {
+		if result.Clone != nil {
+			fmt.Println(result.String())
+		}
+	}
+	return nil
+}
+
+// checkCarryMode validates the --carry mode. Since the mode is optional, it
+// must be attached with '=', and `--carry move BRANCH` would carry a copy
+// into a branch named move, so that's rejected.
+func checkCarryMode(carryMode string, args []string) error {
+	if carryMode != "" && carryMode != CARRY_COPY && carryMode != CARRY_MOVE {
+		return fmt.Errorf("--carry must be %s or %s: %s", CARRY_COPY, CARRY_MOVE, carryMode)
+	}
+	if carryMode == CARRY_COPY && len(args) > 0 && (args[0] == CARRY_COPY || args[0] == CARRY_MOVE) {
+		return fmt.Errorf("--carry takes its mode after '=', as in --carry=%s", args[0])
+	}
+	return nil
+}
+
+// captureCarriedChanges captures the uncommitted changes of the checkout
+// containing the working directory, returning nil if there are none
+func captureCarriedChanges() (*internal.CarriedChanges, error) {
+	checkout, err := internal.FindCurrentCheckout()
+	if err != nil {
+		return nil, fmt.Errorf("--carry must be run from a checkout: %w", err)
+	}
+	carry, err := internal.CaptureCarriedChanges(checkout)
+	if err != nil {
+		return nil, err
+	}
+	if carry.Empty() {
+		fmt.Printf("No uncommitted changes to carry from %s\n", checkout)
+	}
+	return carry, nil
+}
+
+// discardCarriedChanges removes carried changes from their source once the
+// worktree they were carried into is ready
+func discardCarriedChanges(carry *internal.CarriedChanges) error {
+	if carry.Empty() {
+		return nil
+	}
+	fmt.Printf("Removing carried changes from %s... ", carry.Source())
+	if err := carry.Discard(); err != nil {
+		fmt.Printf("ERROR\n")
+		return err
+	}
 	fmt.Printf("DONE\n")
 	return nil
 }
 
+// claimPooledWorktree tries to claim a worktree from the warm pool, falling
+// back to creating a new worktree if the claim fails
+func claimPooledWorktree(kw *internal.KoshoWorktree) (bool, error) {
+	claimed, err := kw.ClaimPooledWorktree()
+	if err != nil {
+		fmt.Printf("Failed to claim pooled worktree, creating a new one instead: %v\n", err)
+		return false, nil
+	} else if !claimed {
+		return false, nil
+	}
+
+	fmt.Printf("Claimed pooled worktree for '%s'\n", kw.Name())
+
+	// the branch wasn't known when the create hook ran in the pool
+	err = internal.RunBranchConfigHooks(kw, internal.HOOK_CREATE)
+	return true, handleHookFailure(kw, internal.HOOK_CREATE, true, err)
+}
+
 func init() {
+	// stop parsing flags at BRANCH so that flags are passed to the command
+	runCmd.Flags().SetInterspersed(false)
+	runCmd.Flags().String("sparse", "", "Comma separated directories to check out when creating the worktree (cone mode sparse checkout)")
+	runCmd.Flags().String("dir", "", "Run the command in this directory relative to the worktree root")
+	runCmd.Flags().String("carry", "", "Carry the current checkout's uncommitted changes into the new worktree: copy, or move to also remove them from the checkout")
+	runCmd.Flags().Lookup("carry").NoOptDefVal = CARRY_COPY
+	runCmd.Flags().Bool("auto", false, "Generate BRANCH from the branch_template config instead of taking it as an argument")
+	runCmd.Flags().Bool("detach", false, "Check out BRANCH, which may be any ref, at a detached HEAD rather than as a branch")
+	runCmd.Flags().Bool("no-create", false, "Fail if BRANCH has no worktree instead of creating one")
+	runCmd.Flags().String("name", "", "Name of the worktree directory when creating the worktree, defaults to a slug of BRANCH")
+
 	rootCmd.AddCommand(runCmd)
 }
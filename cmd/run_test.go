@@ -0,0 +1,29 @@
+package cmd
+
+import "testing"
+
+func TestCheckCarryMode(t *testing.T) {
+	tests := []struct {
+		carryMode string
+		args      []string
+		valid     bool
+	}{
+		{"", []string{"feat", "make"}, true},
+		{"", []string{"move", "make"}, true},
+		{CARRY_COPY, []string{"feat", "make"}, true},
+		{CARRY_MOVE, []string{"feat", "make"}, true},
+		{CARRY_MOVE, []string{"copy", "make"}, true},
+		{CARRY_COPY, nil, true},
+		{CARRY_COPY, []string{"move", "feat", "make"}, false},
+		{CARRY_COPY, []string{"copy", "feat", "make"}, false},
+		{"stash", []string{"feat", "make"}, false},
+	}
+	for _, tt := range tests {
+		err := checkCarryMode(tt.carryMode, tt.args)
+		if tt.valid && err != nil {
+			t.Errorf("checkCarryMode(%q, %q): unexpected error: %v", tt.carryMode, tt.args, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("checkCarryMode(%q, %q): expected an error", tt.carryMode, tt.args)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var sparseCmd = &cobra.Command{
+	Use:   "sparse NAME add|remove DIR...",
+	Short: "Change the directories checked out in a sparse worktree",
+	Long: `Add or remove directories from the cone mode sparse checkout of the
+worktree NAME, or the worktree of the branch NAME. Adding directories to a
+full checkout makes it sparse, and removing every directory restores a full
+checkout.`,
+	Example:           "kosho sparse bugfix add services/web\nkosho sparse bugfix remove libs/common",
+	Args:              cobra.MinimumNArgs(3),
+	ValidArgsFunction: internal.WorktreeCompletion,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, action := args[0], args[1]
+
+		paths, err := internal.ParseSparsePaths(strings.Join(args[2:], ","))
+		if err != nil {
+			return err
+		}
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		kw, err := koshoDir.FindNamedWorktree(name)
+		if err != nil {
+			return err
+		}
+
+		switch action {
+		case "add":
+			err = kw.SparseAdd(paths)
+		case "remove":
+			err = kw.SparseRemove(paths)
+		default:
+			return fmt.Errorf("unknown action %q, expected add or remove", action)
+		}
+		if err != nil {
+			return err
+		}
+
+		current, err := kw.SparsePaths()
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			fmt.Printf("Worktree '%s' has a full checkout\n", kw.Name())
+		} else {
+			fmt.Printf("Worktree '%s' checks out: %s\n", kw.Name(), strings.Join(current, ", "))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sparseCmd)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/carlsverre/kosho/internal"
+
+	"github.com/spf13/cobra"
+)
+
+var tmpCmd = &cobra.Command{
+	Use:   "tmp [flags] COMMAND [args...]",
+	Short: "Runs COMMAND in a temporary worktree which is removed afterwards",
+	Long: `Creates a uniquely named worktree with a detached HEAD at --at, which
+defaults to HEAD, runs the create and run hooks and COMMAND in it, and removes
+it once COMMAND exits. kosho exits with COMMAND's exit code.
+
+With --keep-on-failure, the worktree is kept for inspection if a hook or
+COMMAND fails. Temporary worktrees left behind by kosho processes which were
+killed are removed by the next 'kosho tmp'. Flags must come before COMMAND.`,
+	Example: "kosho tmp make test\nkosho tmp --at v1.2.3 --keep-on-failure ./repro.sh",
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		at, _ := cmd.Flags().GetString("at")
+		keep, _ := cmd.Flags().GetBool("keep-on-failure")
+
+		koshoDir, err := internal.LoadKoshoDir()
+		if err != nil {
+			return fmt.Errorf("failed to load Kosho dir: %w", err)
+		}
+
+		collected, err := koshoDir.CollectTmpWorktrees()
+		for _, name := range collected {
+			fmt.Printf("Removed leftover temporary worktree '%s'\n", name)
+		}
+		if err != nil {
+			return err
+		}
+
+		kw, err := koshoDir.NewTmpWorktree(at)
+		if err != nil {
+			return err
+		}
+		if err := createWorktree(kw, internal.CreateOptions{TmpPid: os.Getpid()}); err != nil {
+			return err
+		}
+
+		if err := runHook(kw, internal.HOOK_CREATE, !keep); err != nil {
+			return errors.Join(err, keepTmpWorktree(kw, keep))
+		}
+		if err := runHook(kw, internal.HOOK_RUN, !keep, fmt.Sprintf("KOSHO_CMD=%q", args[0])); err != nil {
+			return errors.Join(err, keepTmpWorktree(kw, keep))
+		}
+
+		// let the command handle interrupts, so that kosho survives to clean
+		// up after it
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		runErr := kw.RunCommand("", args)
+		signal.Stop(interrupts)
+
+		if runErr != nil && keep {
+			if err := keepTmpWorktree(kw, keep); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("Removing worktree '%s'... ", kw.Name())
+			if err := kw.Remove(true); err != nil {
+				fmt.Printf("ERROR\n")
+				return errors.Join(runErr, err)
+			}
+			fmt.Printf("DONE\n")
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			return &ExitCodeError{Code: commandExitCode(exitErr)}
+		}
+		return runErr
+	},
+}
+
+// commandExitCode returns the exit code of a command, following the shell
+// convention of 128 plus the signal number for commands killed by a signal
+func commandExitCode(exitErr *exec.ExitError) int {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+// keepTmpWorktree stops a temporary worktree from being removed as a
+// leftover if keep is set, once a hook or its command has failed
+func keepTmpWorktree(kw *internal.KoshoWorktree, keep bool) error {
+	if !keep {
+		return nil
+	}
+	if err := kw.KeepTmpWorktree(); err != nil {
+		return err
+	}
+	fmt.Printf("Keeping worktree '%s' at %s for inspection, remove it with `kosho prune`\n", kw.Name(), kw.WorktreePath())
+	return nil
+}
+
+func init() {
+	// stop parsing flags at COMMAND so that flags are passed to the command
+	tmpCmd.Flags().SetInterspersed(false)
+	tmpCmd.Flags().String("at", "HEAD", "Check out the worktree at this ref")
+	tmpCmd.Flags().Bool("keep-on-failure", false, "Keep the worktree for inspection if a hook or the command fails")
+	rootCmd.AddCommand(tmpCmd)
+}
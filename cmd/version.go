@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"runtime/debug"
 
+	"github.com/carlsverre/kosho/internal"
+
 	"github.com/spf13/cobra"
 )
 
@@ -15,6 +17,7 @@ var versionCmd = &cobra.Command{
 		info, ok := debug.ReadBuildInfo()
 		if !ok {
 			fmt.Println("kosho dev (commit unknown)")
+			fmt.Printf(".kosho layout version %d\n", internal.KoshoLayoutVersion())
 			return
 		}
 
@@ -27,6 +30,8 @@ var versionCmd = &cobra.Command{
 			}
 		}
 
+		fmt.Printf(".kosho layout version %d\n", internal.KoshoLayoutVersion())
+
 	},
 }
 
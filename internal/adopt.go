@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ExternalWorktrees returns the worktrees registered with git which kosho
+// doesn't manage, excluding the main worktree and missing worktrees
+func (kr *KoshoDir) ExternalWorktrees() ([]GitWorktree, error) {
+	gitWorktrees, err := ListGitWorktrees(kr.repoPath)
+	if err != nil {
+		return nil, err
+	}
+	worktrees, err := kr.ListWorktrees()
+	if err != nil {
+		return nil, err
+	}
+
+	managed := make(map[string]bool)
+	for _, kw := range worktrees {
+		if kw.State != WORKTREE_ORPHAN {
+			managed[resolvePath(kw.WorktreePath())] = true
+		}
+	}
+	poolDir := resolvePath(kr.PoolDir())
+
+	var external []GitWorktree
+	for i, gitWorktree := range gitWorktrees {
+		path := resolvePath(gitWorktree.Path)
+		if i == 0 || gitWorktree.Bare || gitWorktree.Prunable || managed[path] || filepath.Dir(path) == poolDir {
+			continue
+		}
+		external = append(external, gitWorktree)
+	}
+	return external, nil
+}
+
+// AdoptWorktree brings the git worktree at path under kosho management. If
+// move is set, the worktree is moved into the worktrees directory and named
+// after its branch, otherwise it's recorded in place and keeps the name of its
+// directory.
+func (kr *KoshoDir) AdoptWorktree(path string, move bool) (*KoshoWorktree, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+
+	external, err := kr.ExternalWorktrees()
+	if err != nil {
+		return nil, err
+	}
+	var gitWorktree *GitWorktree
+	for i := range external {
+		if resolvePath(external[i].Path) == resolvePath(path) {
+			gitWorktree = &external[i]
+			break
+		}
+	}
+	if gitWorktree == nil {
+		return nil, fmt.Errorf("%s is not an unmanaged worktree of %s", path, kr.repoPath)
+	}
+
+	name := filepath.Base(gitWorktree.Path)
+	if move && gitWorktree.Branch != "" {
+		name = sluggify(gitWorktree.Branch)
+	}
+	kw := &KoshoWorktree{KoshoDir: *kr, BranchName: gitWorktree.Branch, WorktreeName: name}
+
+	if _, err := os.Stat(kr.MetadataPath(name)); err == nil {
+		return nil, fmt.Errorf("kosho already manages a worktree named '%s'", name)
+	}
+
+	metadata := &WorktreeMetadata{Branch: gitWorktree.Branch, AdoptedFrom: gitWorktree.Path}
+	if move {
+		if err := kw.MoveFrom(gitWorktree.Path); err != nil {
+			return nil, err
+		}
+	} else {
+		kw.path = gitWorktree.Path
+		metadata.AdoptedInPlace = true
+	}
+
+	if err := kw.SaveMetadata(metadata); err != nil {
+		return nil, err
+	}
+	return kw, nil
+}
+
+// Release stops kosho from managing the worktree without removing it.
+// Worktrees stored in the worktrees directory are moved to dest, or back to
+// where they were adopted from if dest is empty. Returns the worktree's path.
+func (kw *KoshoWorktree) Release(dest string) (string, error) {
+	switch kw.State {
+	case WORKTREE_ORPHAN, WORKTREE_PRUNABLE:
+		return "", fmt.Errorf("worktree '%s' is %s, run `kosho doctor` first", kw.Name(), kw.State)
+	}
+
+	metadata, err := kw.LoadMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	path := kw.WorktreePath()
+	if kw.path == "" {
+		if dest == "" {
+			dest = metadata.AdoptedFrom
+		}
+		if dest == "" {
+			return "", fmt.Errorf("worktree '%s' wasn't adopted, specify where to move it", kw.Name())
+		}
+		if dest, err = filepath.Abs(dest); err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", dest, err)
+		}
+
+		if err := moveWorktree(kw.KoshoDir.RepoPath(), path, dest); err != nil {
+			return "", err
+		}
+		path = dest
+	} else if dest != "" {
+		return "", fmt.Errorf("worktree '%s' is already stored outside the worktrees directory at %s", kw.Name(), path)
+	}
+
+	return path, kw.RemoveMetadata()
+}
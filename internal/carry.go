@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// CarriedChanges are the uncommitted changes of a checkout being carried
+// into a new worktree
+type CarriedChanges struct {
+	changes *workingChanges
+
+	// HEAD of the checkout when the changes were captured
+	head string
+
+	// Digest of the untracked files' contents, which the diffs don't cover
+	untrackedDigest []byte
+}
+
+// CaptureCarriedChanges records the staged, unstaged and untracked changes in
+// the checkout at root without modifying it
+func CaptureCarriedChanges(root string) (*CarriedChanges, error) {
+	output, err := gitOutput(root, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD of %s: %w", root, err)
+	}
+	changes, err := captureChanges(root, true)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := digestFiles(root, changes.untracked)
+	if err != nil {
+		return nil, err
+	}
+	return &CarriedChanges{
+		changes:         changes,
+		head:            strings.TrimSpace(string(output)),
+		untrackedDigest: digest,
+	}, nil
+}
+
+// Source returns the root of the checkout the changes were captured from
+func (c *CarriedChanges) Source() string {
+	return c.changes.root
+}
+
+func (c *CarriedChanges) Empty() bool {
+	return c.changes.empty()
+}
+
+// Discard removes the changes from the checkout they were captured from.
+// Only call this once the worktree they were carried into is set up. Refuses
+// if the checkout has changed since the changes were captured, such as by a
+// hook or an editor, since those edits weren't carried.
+func (c *CarriedChanges) Discard() error {
+	root := c.changes.root
+	unchanged, err := c.unchanged()
+	if err != nil {
+		return err
+	}
+	if !unchanged {
+		return fmt.Errorf("%s changed after its changes were carried, leaving them in place", root)
+	}
+
+	cmd := exec.Command("git", "reset", "--hard", "--quiet")
+	cmd.Dir = root
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to reset %s: %w\nOutput: %s", root, err, string(output))
+	}
+	for _, path := range c.changes.untracked {
+		if err := os.Remove(filepath.Join(root, path)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// unchanged reports whether the checkout still has exactly the changes which
+// were captured
+func (c *CarriedChanges) unchanged() (bool, error) {
+	root := c.changes.root
+	output, err := gitOutput(root, "rev-parse", "HEAD")
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve HEAD of %s: %w", root, err)
+	}
+	if strings.TrimSpace(string(output)) != c.head {
+		return false, nil
+	}
+
+	current, err := captureChanges(root, true)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current.staged, c.changes.staged) ||
+		!bytes.Equal(current.unstaged, c.changes.unstaged) ||
+		!slices.Equal(current.untracked, c.changes.untracked) {
+		return false, nil
+	}
+
+	digest, err := digestFiles(root, current.untracked)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(digest, c.untrackedDigest), nil
+}
+
+// digestFiles hashes the names and contents of the files at paths relative to
+// root. Symlinks are hashed by their target.
+func digestFiles(root string, paths []string) ([]byte, error) {
+	hash := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(hash, "%s\x00", path)
+		fullPath := filepath.Join(root, path)
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		fmt.Fprintf(hash, "%s\x00", info.Mode())
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(fullPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read link %s: %w", path, err)
+			}
+			fmt.Fprintf(hash, "%s\x00", target)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		file, err := os.Open(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// workingChanges are the uncommitted changes in a checkout, captured without
+// modifying it
+type workingChanges struct {
+	root string
+
+	// Binary diffs of the index against HEAD, and the working tree against
+	// the index
+	staged   []byte
+	unstaged []byte
+
+	// Untracked files which aren't ignored, relative to root
+	untracked []string
+}
+
+// captureChanges records the staged and unstaged changes in the checkout at
+// root, along with its untracked files if includeUntracked is set
+func captureChanges(root string, includeUntracked bool) (*workingChanges, error) {
+	changes := &workingChanges{root: root}
+
+	var err error
+	if changes.staged, err = gitOutput(root, "diff", "--cached", "--binary", "--no-color", "--no-ext-diff"); err != nil {
+		return nil, fmt.Errorf("failed to capture staged changes: %w", err)
+	}
+	if changes.unstaged, err = gitOutput(root, "diff", "--binary", "--no-color", "--no-ext-diff"); err != nil {
+		return nil, fmt.Errorf("failed to capture unstaged changes: %w", err)
+	}
+
+	if includeUntracked {
+		output, err := gitOutput(root, "ls-files", "--others", "--exclude-standard", "-z")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list untracked files: %w", err)
+		}
+		for _, path := range strings.Split(string(output), "\x00") {
+			// the .kosho directory belongs to the repository, not the checkout
+			if path != "" && !strings.HasPrefix(path, KOSHO_DIR+"/") {
+				changes.untracked = append(changes.untracked, path)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+func (c *workingChanges) empty() bool {
+	return len(c.staged) == 0 && len(c.unstaged) == 0 && len(c.untracked) == 0
+}
+
+// applyTo applies the changes to the checkout at dest, which must be at the
+// same commit as the checkout they were captured from. Staged changes are
+// staged in dest too.
+func (c *workingChanges) applyTo(dest string) error {
+	if len(c.staged) > 0 {
+		if err := gitApply(dest, c.staged, "--index"); err != nil {
+			return fmt.Errorf("failed to apply staged changes: %w", err)
+		}
+	}
+	if len(c.unstaged) > 0 {
+		if err := gitApply(dest, c.unstaged); err != nil {
+			return fmt.Errorf("failed to apply unstaged changes: %w", err)
+		}
+	}
+
+	for _, path := range c.untracked {
+		src := filepath.Join(c.root, path)
+		target := filepath.Join(dest, path)
+
+		info, err := os.Lstat(src)
+		if os.IsNotExist(err) {
+			// removed since the changes were captured
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", src, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			// provisioning may have created the file already
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", path, err)
+			}
+			continue
+		}
+		if err := copyFile(src, target, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w\nOutput: %s", err, stderr.String())
+	}
+	return output, nil
+}
+
+func gitApply(dir string, patch []byte, args ...string) error {
+	cmd := exec.Command("git", append([]string{"apply", "--binary"}, args...)...)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(patch)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
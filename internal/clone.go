@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type CloneMethod string
+
+const (
+	// Share the file contents using copy-on-write reflinks
+	CLONE_REFLINK CloneMethod = "reflink"
+
+	// Hardlink each file, sharing the inode with the source
+	CLONE_HARDLINK CloneMethod = "hardlink"
+
+	// Copy the file contents
+	CLONE_COPY CloneMethod = "copy"
+)
+
+// CloneMethods lists the clone methods in order of preference
+var CloneMethods = []CloneMethod{CLONE_REFLINK, CLONE_HARDLINK, CLONE_COPY}
+
+// CloneStats describes the result of cloning a directory tree
+type CloneStats struct {
+	// Number of files cloned with each method
+	Methods map[CloneMethod]int
+
+	Files    int
+	Bytes    int64
+	Duration time.Duration
+
+	// Bytes shared with the source through reflinks or hardlinks instead of
+	// being copied, and an estimate of how long copying them would have taken
+	// on top of Duration
+	SharedBytes int64
+	Saved       time.Duration
+}
+
+// sampleCopySize limits how much is copied to estimate the copy throughput
+// when no files had to be copied
+const sampleCopySize = 16 << 20
+
+// cloneTree recursively clones src into dest, preferring reflinks and falling
+// back to the given method once a file can't be reflinked. Hardlinks fall
+// back to copies when src and dest are on different filesystems.
+func cloneTree(src, dest string, fallback CloneMethod) (CloneStats, error) {
+	start := time.Now()
+	stats := CloneStats{Methods: make(map[CloneMethod]int)}
+	method := CLONE_REFLINK
+
+	// the copies made, to estimate how long copying the shared files would
+	// have taken, and the largest shared file to measure if there are none
+	var copiedBytes int64
+	var copyDuration time.Duration
+	var largestShared string
+	var largestSharedSize int64
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !d.Type().IsRegular():
+			// skip sockets, devices and other special files
+			return nil
+		}
+
+		stats.Files++
+		stats.Bytes += info.Size()
+
+		shared := func(used CloneMethod) error {
+			stats.Methods[used]++
+			stats.SharedBytes += info.Size()
+			if info.Size() > largestSharedSize {
+				largestShared, largestSharedSize = path, info.Size()
+			}
+			return nil
+		}
+
+		if method == CLONE_REFLINK {
+			if err := reflinkFile(path, target, info.Mode().Perm()); err == nil {
+				return shared(CLONE_REFLINK)
+			}
+			method = fallback
+		}
+
+		if method == CLONE_HARDLINK {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Link(path, target); err == nil {
+				return shared(CLONE_HARDLINK)
+			}
+			method = CLONE_COPY
+		}
+
+		copyStart := time.Now()
+		if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		copyDuration += time.Since(copyStart)
+		copiedBytes += info.Size()
+		stats.Methods[CLONE_COPY]++
+		return nil
+	})
+
+	stats.Duration = time.Since(start)
+	if err == nil && stats.SharedBytes > 0 {
+		if copiedBytes == 0 {
+			copiedBytes, copyDuration = measureCopy(largestShared, dest)
+		}
+		if copiedBytes > 0 {
+			estimate := time.Duration(float64(copyDuration) * float64(stats.SharedBytes) / float64(copiedBytes))
+			stats.Saved = max(estimate-(stats.Duration-copyDuration), 0)
+		}
+	}
+	return stats, err
+}
+
+// measureCopy copies up to sampleCopySize bytes of src to a temporary file in
+// dir, returning how many bytes were copied and how long it took
+func measureCopy(src, dir string) (int64, time.Duration) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, 0
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(dir, ".kosho-copy-*")
+	if err != nil {
+		return 0, 0
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	start := time.Now()
+	n, err := io.Copy(out, io.LimitReader(in, sampleCopySize))
+	if err != nil {
+		return 0, 0
+	}
+	if err := out.Sync(); err != nil {
+		return 0, 0
+	}
+	return n, time.Since(start)
+}
+
+// formatBytes formats a byte count using binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const KOSHO_TEMPLATE_DIR = "template"
+
+// CloneOptions controls how `kosho clone` clones a repository
+type CloneOptions struct {
+	// Clone as a bare repository with the default branch checked out in a
+	// kosho worktree
+	Bare bool
+}
+
+// CloneDir returns the directory git would clone url into
+func CloneDir(url string, bare bool) string {
+	name := strings.TrimRight(url, "/")
+	name = strings.TrimSuffix(name, "/.git")
+	// handle scp-like urls such as host:repo.git
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, ".git")
+	if bare {
+		return name + ".git"
+	}
+	return name
+}
+
+// CloneRepo clones url into dir, configuring bare clones to fetch every
+// branch like a regular clone does. Returns the absolute path of the clone.
+func CloneRepo(url, dir string, opts CloneOptions) (string, error) {
+	args := []string{"clone"}
+	if opts.Bare {
+		args = append(args, "--bare")
+	}
+	args = append(args, url, dir)
+
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to clone %s: %w", url, err)
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+
+	// `git clone --bare` doesn't create remote tracking branches, which
+	// worktrees need for their upstreams
+	if opts.Bare {
+		cmd := exec.Command("git", "config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("failed to configure remote: %w\nOutput: %s", err, string(output))
+		}
+		cmd = exec.Command("git", "fetch", "--quiet", "origin")
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("failed to fetch remote branches: %w\nOutput: %s", err, string(output))
+		}
+
+		branch, err := DefaultBranch(dir)
+		if err != nil {
+			return "", err
+		}
+		cmd = exec.Command("git", "branch", "--set-upstream-to=origin/"+branch, branch)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("failed to set upstream of %s: %w\nOutput: %s", branch, err, string(output))
+		}
+	}
+
+	return dir, nil
+}
+
+// ApplyKoshoTemplate copies the files in templateDir into the .kosho
+// directory of the repository at repoDir, keeping any files the repository
+// already has. If templateDir is empty, the user-global template is used if it
+// exists.
+func ApplyKoshoTemplate(repoDir, templateDir string) error {
+	if templateDir == "" {
+		configDir, err := GlobalConfigDir()
+		if err != nil {
+			return err
+		}
+		templateDir = filepath.Join(configDir, KOSHO_TEMPLATE_DIR)
+		if _, err := os.Stat(templateDir); os.IsNotExist(err) {
+			return nil
+		}
+	}
+
+	koshoDir := filepath.Join(repoDir, KOSHO_DIR)
+	return filepath.WalkDir(templateDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read template: %w", err)
+		}
+
+		rel, err := filepath.Rel(templateDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(koshoDir, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to copy %s from template: %w", rel, err)
+		}
+		return nil
+	})
+}
+
+// DefaultBranch returns the branch HEAD points to in the repository
+func DefaultBranch(gitRoot string) (string, error) {
+	cmd := exec.Command("git", "-C", gitRoot, "symbolic-ref", "--short", "HEAD")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find default branch: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -81,3 +81,66 @@ func isExecutable(path string) bool {
 	// Check if it's a regular file and has execute permission
 	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
 }
+
+// BranchCompletion provides autocompletion for commands which take a single
+// branch name
+func BranchCompletion(cmd *cobra.Command, args []string, prefix string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	repoRoot, err := FindGitRoot()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	branches, err := ListBranches(repoRoot)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	return branches, cobra.ShellCompDirectiveNoFileComp
+}
+
+// HookNameCompletion provides autocompletion for commands which take a hook name
+func HookNameCompletion(cmd *cobra.Command, args []string, prefix string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	hooks := make([]string, 0, len(KoshoHookTypes))
+	for _, hook := range KoshoHookTypes {
+		hooks = append(hooks, string(hook))
+	}
+	return hooks, cobra.ShellCompDirectiveNoFileComp
+}
+
+// HooksRunCompletion provides autocompletion for `kosho hooks run`
+func HooksRunCompletion(cmd *cobra.Command, args []string, prefix string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return HookNameCompletion(cmd, args, prefix)
+	case 1:
+		return WorktreeCompletion(cmd, nil, prefix)
+	case 2:
+		return getExecutablesFromPath(prefix), cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// WorktreeCompletion provides autocompletion for commands which take the name
+// of a kosho worktree
+func WorktreeCompletion(cmd *cobra.Command, args []string, prefix string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	koshoDir, err := OpenKoshoDir()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	worktrees, err := koshoDir.ListWorktrees()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	names := make([]string, 0, len(worktrees))
+	for _, kw := range worktrees {
+		names = append(names, kw.Name())
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
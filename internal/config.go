@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	KOSHO_CONFIG_FILE = "config.json"
+)
+
+// KoshoConfig is the repository level kosho configuration stored in
+// .kosho/config.json
+type KoshoConfig struct {
+	// Set to false to skip running the user-global hooks in this repository
+	GlobalHooks *bool `json:"global_hooks,omitempty"`
+
+	// Hooks declared inline, run after the hook files in .kosho/hooks
+	Hooks map[KoshoHook][]ConfigHook `json:"hooks,omitempty"`
+
+	// Untracked files and directories provisioned into each new worktree
+	Provision []ProvisionRule `json:"provision,omitempty"`
+
+	// Set to false to skip initializing submodules in new worktrees
+	Submodules *bool `json:"submodules,omitempty"`
+
+	// Directory to store worktrees in instead of .kosho/worktrees. Each
+	// repository's worktrees are stored in a subdirectory named after the
+	// repository. May also be set in the user-global config.
+	WorktreeRoot string `json:"worktree_root,omitempty"`
+
+	// Template for the branch names generated by `kosho run --auto`, e.g.
+	// "{user}/{date}-{words}". May also be set in the user-global config.
+	BranchTemplate string `json:"branch_template,omitempty"`
+}
+
+// ConfigHook is a hook command declared in the kosho config
+type ConfigHook struct {
+	// The command and its arguments
+	Command []string `json:"command"`
+
+	// Only run the hook for branches matching one of these glob patterns.
+	// The hook runs for every branch if this is empty.
+	Branches []string `json:"branches,omitempty"`
+
+	// Working directory relative to the worktree root
+	Dir string `json:"dir,omitempty"`
+
+	// Extra environment variables passed to the command
+	Env map[string]string `json:"env,omitempty"`
+
+	// Maximum duration of the command, e.g. "5m"
+	Timeout string `json:"timeout,omitempty"`
+
+	// Files or glob patterns relative to the worktree root. When set, the
+	// hook only runs if their contents changed since its last successful run.
+	Watch []string `json:"watch,omitempty"`
+}
+
+// MatchesBranch returns true if the hook should run for the given branch
+func (h *ConfigHook) MatchesBranch(branch string) bool {
+	if len(h.Branches) == 0 {
+		return true
+	}
+	for _, pattern := range h.Branches {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeoutDuration returns the parsed timeout, or zero if there is no timeout
+func (h *ConfigHook) TimeoutDuration() time.Duration {
+	timeout, _ := time.ParseDuration(h.Timeout)
+	return timeout
+}
+
+func (h *ConfigHook) validate() error {
+	if len(h.Command) == 0 {
+		return fmt.Errorf("command is required")
+	}
+	for _, pattern := range h.Branches {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid branch pattern %q: %w", pattern, err)
+		}
+	}
+	if filepath.IsAbs(h.Dir) {
+		return fmt.Errorf("dir must be relative to the worktree: %s", h.Dir)
+	}
+	for _, pattern := range h.Watch {
+		if filepath.IsAbs(pattern) {
+			return fmt.Errorf("watch paths must be relative to the worktree: %s", pattern)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid watch pattern %q: %w", pattern, err)
+		}
+	}
+	if h.Timeout != "" {
+		if _, err := time.ParseDuration(h.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", h.Timeout, err)
+		}
+	}
+	return nil
+}
+
+func (c *KoshoConfig) validate() error {
+	for hook, configHooks := range c.Hooks {
+		if _, err := ParseKoshoHook(string(hook)); err != nil {
+			return err
+		}
+		for i := range configHooks {
+			if err := configHooks[i].validate(); err != nil {
+				return fmt.Errorf("invalid %s hook %d: %w", hook, i, err)
+			}
+		}
+	}
+	for i := range c.Provision {
+		if err := c.Provision[i].validate(); err != nil {
+			return fmt.Errorf("invalid provision rule %d: %w", i, err)
+		}
+	}
+	if err := validateBranchTemplate(c.BranchTemplate); err != nil {
+		return fmt.Errorf("invalid branch template: %w", err)
+	}
+	return nil
+}
+
+// UseGlobalHooks returns true if the user-global hooks should run in this repository
+func (c *KoshoConfig) UseGlobalHooks() bool {
+	return c.GlobalHooks == nil || *c.GlobalHooks
+}
+
+// UseSubmodules returns true if submodules should be initialized in new worktrees
+func (c *KoshoConfig) UseSubmodules() bool {
+	return c.Submodules == nil || *c.Submodules
+}
+
+// loadKoshoConfig reads the config file at configPath, returning an empty
+// config if the file doesn't exist
+func loadKoshoConfig(configPath string) (KoshoConfig, error) {
+	var config KoshoConfig
+
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return config, fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("invalid config %s: %w", configPath, err)
+	}
+	return config, nil
+}
+
+// loadGlobalKoshoConfig reads the user-global config file, usually
+// ~/.config/kosho/config.json
+func loadGlobalKoshoConfig() (KoshoConfig, error) {
+	configDir, err := GlobalConfigDir()
+	if err != nil {
+		return KoshoConfig{}, err
+	}
+	return loadKoshoConfig(filepath.Join(configDir, KOSHO_CONFIG_FILE))
+}
+
+// expandHome replaces a leading ~ in path with the user's home directory
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// GlobalConfigDir returns the user-global kosho configuration directory,
+// usually ~/.config/kosho
+func GlobalConfigDir() (string, error) {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "kosho"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "kosho"), nil
+}
@@ -0,0 +1,54 @@
+package internal
+
+import "testing"
+
+func TestConfigHookMatchesBranch(t *testing.T) {
+	tests := []struct {
+		branches []string
+		branch   string
+		want     bool
+	}{
+		{nil, "main", true},
+		{[]string{"main"}, "main", true},
+		{[]string{"main"}, "mainline", false},
+		{[]string{"feat/*", "fix/*"}, "feat/login", true},
+		{[]string{"feat/*", "fix/*"}, "fix/crash", true},
+		{[]string{"feat/*", "fix/*"}, "chore/deps", false},
+		{[]string{"feat/*"}, "feat/api/v2", false},
+		{[]string{"feat/*/*"}, "feat/api/v2", true},
+		{[]string{"release-?.?"}, "release-1.2", true},
+		{[]string{"[ab]*"}, "bugfix", true},
+		{[]string{"[ab]*"}, "cleanup", false},
+	}
+	for _, tt := range tests {
+		hook := ConfigHook{Command: []string{"true"}, Branches: tt.branches}
+		if got := hook.MatchesBranch(tt.branch); got != tt.want {
+			t.Errorf("MatchesBranch(%q) with branches %q = %v, want %v", tt.branch, tt.branches, got, tt.want)
+		}
+	}
+}
+
+func TestConfigHookValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		hook  ConfigHook
+		valid bool
+	}{
+		{"minimal", ConfigHook{Command: []string{"true"}}, true},
+		{"no command", ConfigHook{}, false},
+		{"bad branch pattern", ConfigHook{Command: []string{"true"}, Branches: []string{"feat/["}}, false},
+		{"absolute dir", ConfigHook{Command: []string{"true"}, Dir: "/tmp"}, false},
+		{"absolute watch", ConfigHook{Command: []string{"true"}, Watch: []string{"/etc/passwd"}}, false},
+		{"bad watch pattern", ConfigHook{Command: []string{"true"}, Watch: []string{"src/["}}, false},
+		{"timeout", ConfigHook{Command: []string{"true"}, Timeout: "5m"}, true},
+		{"bad timeout", ConfigHook{Command: []string{"true"}, Timeout: "soon"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.hook.validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var commitRegex = regexp.MustCompile(`^[0-9a-f]{7,64}$`)
+
+// ResolveCommit returns the full hash of the commit ref points to
+func ResolveCommit(gitRoot, ref string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	cmd.Dir = gitRoot
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is not a commit", ref)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// IsDetachedRef reports whether ref should be checked out at a detached HEAD
+// rather than as a branch: it isn't a local branch, and it's a tag or a
+// commit hash
+func IsDetachedRef(gitRoot, ref string) bool {
+	if BranchExists(gitRoot, ref) {
+		return false
+	}
+	if commitRegex.MatchString(ref) {
+		_, err := ResolveCommit(gitRoot, ref)
+		return err == nil
+	}
+	cmd := exec.Command("git", "show-ref", "--quiet", "--verify", "refs/tags/"+ref)
+	cmd.Dir = gitRoot
+	return cmd.Run() == nil
+}
+
+// DescribeHead describes a detached HEAD by the tag pointing at it, falling
+// back to its abbreviated commit hash
+func (kw *KoshoWorktree) DescribeHead() (string, error) {
+	cmd := exec.Command("git", "describe", "--tags", "--exact-match", "HEAD")
+	cmd.Dir = kw.WorktreePath()
+	if output, err := cmd.Output(); err == nil {
+		return strings.TrimSpace(string(output)), nil
+	}
+	return kw.ShortCommit()
+}
+
+// IsDisposable reports whether the worktree is a detached worktree which is
+// still at the commit it was created at, so removing it can't lose any
+// commits. Uncommitted changes are not considered.
+func (kw *KoshoWorktree) IsDisposable() (bool, error) {
+	if kw.DetachedRef == "" {
+		return false, nil
+	}
+	metadata, err := kw.LoadMetadata()
+	if err != nil {
+		return false, err
+	}
+	head, err := ResolveCommit(kw.WorktreePath(), "HEAD")
+	if err != nil {
+		return false, err
+	}
+	return head == metadata.DetachedCommit, nil
+}
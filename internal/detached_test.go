@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsDetachedRef(t *testing.T) {
+	repo := newTestRepo(t)
+	runGit(t, repo, "tag", "v1.0")
+	runGit(t, repo, "branch", "feat")
+	runGit(t, repo, "branch", "cafe123")
+	head := strings.TrimSpace(runGit(t, repo, "rev-parse", "HEAD"))
+
+	tests := []struct {
+		ref  string
+		want bool
+	}{
+		{"v1.0", true},
+		{head, true},
+		{head[:7], true},
+		{"main", false},
+		{"feat", false},
+		{"cafe123", false},
+		{"deadbeef", false},
+		{"v2.0", false},
+		{"HEAD", false},
+	}
+	for _, tt := range tests {
+		if got := IsDetachedRef(repo, tt.ref); got != tt.want {
+			t.Errorf("IsDetachedRef(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DoctorIssue is a problem with the kosho directory or a worktree found by
+// Diagnose
+type DoctorIssue struct {
+	Description string
+
+	// Hint explains how to resolve issues which can't be fixed automatically
+	Hint string
+
+	fix func() error
+}
+
+// Fixable reports whether the issue can be repaired by Fix
+func (issue *DoctorIssue) Fixable() bool {
+	return issue.fix != nil
+}
+
+// Fix repairs the issue
+func (issue *DoctorIssue) Fix() error {
+	if issue.fix == nil {
+		return fmt.Errorf("%s", issue.Hint)
+	}
+	return issue.fix()
+}
+
+// Diagnose checks the kosho directory and worktrees for drift from git's view
+// of the repository. Issues are returned in the order they should be fixed.
+func (kr *KoshoDir) Diagnose() ([]DoctorIssue, error) {
+	var issues []DoctorIssue
+
+	gitIgnoreIssues, err := kr.diagnoseGitIgnore()
+	if err != nil {
+		return nil, err
+	}
+	issues = append(issues, gitIgnoreIssues...)
+
+	worktrees, err := kr.ListWorktrees()
+	if err != nil {
+		return nil, err
+	}
+
+	// worktrees with broken links to the repository show up as orphans, and
+	// their registrations as prunable, until `git worktree repair` fixes them
+	relativeBacklinks := gitSupportsRelativePaths()
+	repaired := make(map[string]bool)
+	for _, kw := range worktrees {
+		if kw.State == WORKTREE_PRUNABLE {
+			continue
+		}
+		broken, err := gitLinkBroken(kw.WorktreePath(), kr.commonDir)
+		if err != nil {
+			return nil, err
+		}
+		if broken {
+			repaired[kw.Name()] = true
+			issues = append(issues, DoctorIssue{
+				Description: fmt.Sprintf("worktree '%s' has a broken link to the repository, was the repository moved?", kw.Name()),
+				fix:         kw.repairGitDir,
+			})
+			continue
+		}
+
+		absolute, err := kw.hasAbsoluteGitLinks(relativeBacklinks)
+		if err != nil {
+			return nil, err
+		}
+		if absolute {
+			issues = append(issues, DoctorIssue{
+				Description: fmt.Sprintf("worktree '%s' is linked to the repository by an absolute path, which breaks when the repository is moved or mounted elsewhere", kw.Name()),
+				fix:         kw.repairGitDir,
+			})
+		}
+	}
+
+	pool, err := kr.ListPool()
+	if err != nil {
+		return nil, err
+	}
+	for _, kw := range pool {
+		broken, err := gitLinkBroken(kw.WorktreePath(), kr.commonDir)
+		if err != nil {
+			return nil, err
+		}
+		if broken {
+			issues = append(issues, DoctorIssue{
+				Description: fmt.Sprintf("pooled worktree %s has a broken link to the repository, was the repository moved?", kw.WorktreePath()),
+				fix:         kw.repairGitDir,
+			})
+		}
+	}
+
+	for _, kw := range worktrees {
+		if repaired[kw.Name()] {
+			continue
+		}
+
+		switch kw.State {
+		case WORKTREE_ORPHAN:
+			issues = append(issues, DoctorIssue{
+				Description: fmt.Sprintf("directory %s is not registered with git", kw.WorktreePath()),
+				Hint:        "move any work you want to keep out of the directory and delete it",
+			})
+		case WORKTREE_PRUNABLE:
+			issues = append(issues, DoctorIssue{
+				Description: fmt.Sprintf("worktree '%s' is registered with git but its directory is missing", kw.Name()),
+				fix: func() error {
+					return kw.Remove(true)
+				},
+			})
+		default:
+			issue, err := kw.diagnoseBranch()
+			if err != nil {
+				return nil, err
+			}
+			if issue != nil {
+				issues = append(issues, *issue)
+			}
+		}
+	}
+
+	for _, scope := range []HookScope{HOOK_SCOPE_GLOBAL, HOOK_SCOPE_REPO} {
+		for _, hook := range KoshoHookTypes {
+			state, err := kr.HookState(scope, hook)
+			if err != nil {
+				return nil, err
+			}
+			if state != HOOK_STATE_NOT_EXECUTABLE {
+				continue
+			}
+			hookFile, err := kr.ScopedHookPath(scope, hook)
+			if err != nil {
+				return nil, err
+			}
+			issues = append(issues, DoctorIssue{
+				Description: fmt.Sprintf("%s hook %s is not executable", scope, hook),
+				fix: func() error {
+					return makeExecutable(hookFile)
+				},
+			})
+		}
+	}
+
+	return issues, nil
+}
+
+func (kr *KoshoDir) diagnoseGitIgnore() ([]DoctorIssue, error) {
+	gitIgnorePath := filepath.Join(kr.repoPath, KOSHO_DIR, ".gitignore")
+	fix := func() error {
+		if err := writeFileIfNotExists(gitIgnorePath, KoshoGitIgnore, 0644); err != nil {
+			return err
+		}
+		return AppendMissingLinesToGitIgnore(gitIgnorePath, KoshoGitIgnore)
+	}
+
+	content, err := os.ReadFile(gitIgnorePath)
+	if os.IsNotExist(err) {
+		return []DoctorIssue{{Description: fmt.Sprintf("%s is missing", gitIgnorePath), fix: fix}}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", gitIgnorePath, err)
+	}
+
+	present := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+	var missing []string
+	for _, line := range strings.Split(string(KoshoGitIgnore), "\n") {
+		if line != "" && !present[line] {
+			missing = append(missing, line)
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	return []DoctorIssue{{
+		Description: fmt.Sprintf("%s doesn't ignore %s", gitIgnorePath, strings.Join(missing, ", ")),
+		fix:         fix,
+	}}, nil
+}
+
+// hasAbsoluteGitLinks reports whether the worktree links to the repository
+// with an absolute path, or the repository links back to the worktree with
+// one when git supports relative links in both directions
+func (kw *KoshoWorktree) hasAbsoluteGitLinks(relativeBacklinks bool) (bool, error) {
+	gitDir, ok, err := readGitLink(kw.WorktreePath())
+	if err != nil || !ok {
+		return false, err
+	}
+	if filepath.IsAbs(gitDir) {
+		return true, nil
+	}
+	if !relativeBacklinks {
+		return false, nil
+	}
+	backlink, err := readBacklink(filepath.Join(kw.WorktreePath(), gitDir))
+	if err != nil {
+		return false, nil
+	}
+	return filepath.IsAbs(backlink), nil
+}
+
+// repairGitDir repairs the links between the worktree and the repository,
+// making them relative
+func (kw *KoshoWorktree) repairGitDir() error {
+	cmd := worktreeCmd("repair", kw.WorktreePath())
+	cmd.Dir = kw.KoshoDir.RepoPath()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to repair worktree: %w\nOutput: %s", err, string(output))
+	}
+	return relativizeGitLink(kw.WorktreePath())
+}
+
+// diagnoseBranch checks that the worktree is still on the branch it was
+// created for
+func (kw *KoshoWorktree) diagnoseBranch() (*DoctorIssue, error) {
+	metadata := kw.KoshoDir.loadMetadata(kw.Name())
+	if metadata == nil || metadata.Branch == "" {
+		return nil, nil
+	}
+
+	branch, err := kw.GitBranch()
+	if err != nil {
+		branch = "a detached HEAD"
+	}
+	if branch == metadata.Branch {
+		return nil, nil
+	}
+
+	issue := &DoctorIssue{
+		Description: fmt.Sprintf("worktree '%s' was created for branch %s but has %s checked out", kw.Name(), metadata.Branch, branch),
+	}
+
+	dirty, err := kw.IsDirty()
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		issue.Hint = fmt.Sprintf("commit or stash the changes in %s and run `git switch %s`", kw.WorktreePath(), metadata.Branch)
+		return issue, nil
+	}
+
+	issue.fix = func() error {
+		cmd := exec.Command("git", "switch", metadata.Branch)
+		cmd.Dir = kw.WorktreePath()
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to switch to branch %s: %w\nOutput: %s", metadata.Branch, err, string(output))
+		}
+		return nil
+	}
+	return issue, nil
+}
+
+func makeExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if err := os.Chmod(path, info.Mode().Perm()|0111); err != nil {
+		return fmt.Errorf("failed to make %s executable: %w", path, err)
+	}
+	return nil
+}
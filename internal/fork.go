@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Fork creates the worktree with a new branch at the HEAD of source, and
+// copies source's staged and unstaged changes into it, along with its
+// untracked files if untracked is set. Source is left untouched. The new
+// worktree is removed along with its branch if any step fails.
+func (kw *KoshoWorktree) Fork(source *KoshoWorktree, untracked bool) ([]ProvisionResult, error) {
+	repoPath := kw.KoshoDir.RepoPath()
+	if BranchExists(repoPath, kw.BranchName) {
+		return nil, fmt.Errorf("branch %s already exists", kw.BranchName)
+	}
+
+	output, err := gitOutput(source.WorktreePath(), "rev-parse", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD of worktree '%s': %w", source.Name(), err)
+	}
+	head := strings.TrimSpace(string(output))
+
+	changes, err := captureChanges(source.WorktreePath(), untracked)
+	if err != nil {
+		return nil, err
+	}
+	sparse, err := source.SparsePaths()
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := kw.CreateWorktree(CreateOptions{Sparse: sparse, From: head})
+	if err != nil {
+		return nil, deleteBranchOnFailure(repoPath, kw.BranchName, err)
+	}
+
+	err = changes.applyTo(kw.WorktreePath())
+	if err == nil {
+		var metadata *WorktreeMetadata
+		if metadata, err = kw.LoadMetadata(); err == nil {
+			metadata.Parent = source.BranchName
+			metadata.ParentCommit = head
+			err = kw.SaveMetadata(metadata)
+		}
+	}
+	if err != nil {
+		if removeErr := kw.Remove(true); removeErr != nil {
+			return nil, fmt.Errorf("%w (failed to remove worktree: %w)", err, removeErr)
+		}
+		return nil, deleteBranchOnFailure(repoPath, kw.BranchName, err)
+	}
+
+	return results, nil
+}
+
+// deleteBranchOnFailure deletes a branch created by a failed command, adding
+// any error to err
+func deleteBranchOnFailure(repoPath, branch string, err error) error {
+	if !BranchExists(repoPath, branch) {
+		return err
+	}
+	cmd := exec.Command("git", "branch", "-D", branch)
+	cmd.Dir = repoPath
+	if output, deleteErr := cmd.CombinedOutput(); deleteErr != nil {
+		return fmt.Errorf("%w (failed to delete branch %s: %w\nOutput: %s)", err, branch, deleteErr, string(output))
+	}
+	return err
+}
@@ -8,34 +8,93 @@ import (
 	"strings"
 )
 
-// FindGitRoot finds the root directory of the git repository, handling both regular repos and worktrees.
+// FindGitRoot finds the directory kosho manages worktrees for: the main
+// worktree of the repository, or the repository itself if it's bare. Works from
+// the main worktree or any linked worktree.
 func FindGitRoot() (string, error) {
-	// First check if we're in a worktree by examining the git directory
-	cmd := exec.Command("git", "rev-parse", "--git-dir")
+	cmd := exec.Command("git", "rev-parse", "--path-format=absolute", "--git-dir", "--git-common-dir")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("not a git repository (or any of the parent directories): %w", err)
 	}
+	dirs := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(dirs) != 2 {
+		return "", fmt.Errorf("unexpected output from git rev-parse: %s", string(output))
+	}
+	gitDir, commonDir := resolvePath(dirs[0]), resolvePath(dirs[1])
 
-	gitDir := strings.TrimSpace(string(output))
+	if gitDir == commonDir {
+		// in the main worktree, which git can find even if core.worktree or
+		// GIT_WORK_TREE moved it away from the git directory
+		cmd = exec.Command("git", "rev-parse", "--show-toplevel")
+		if output, err := cmd.Output(); err == nil {
+			return resolvePath(strings.TrimSpace(string(output))), nil
+		}
+	}
 
-	// Check if we're in a worktree (git-dir contains .git/worktrees/)
-	if strings.Contains(gitDir, ".git/worktrees/") {
-		// Extract main repo path: /path/to/repo/.git/worktrees/name -> /path/to/repo/.git -> /path/to/repo
-		mainGitDir := strings.Split(gitDir, "/worktrees/")[0]
-		mainRepoRoot := filepath.Dir(mainGitDir)
-		return mainRepoRoot, nil
+	bare, err := isBareRepository(commonDir)
+	if err != nil {
+		return "", err
+	}
+	if bare {
+		return commonDir, nil
 	}
+	// the main worktree is the parent of the git directory unless
+	// core.worktree says otherwise, as it does for submodules
+	cmd = exec.Command("git", "--git-dir", commonDir, "config", "--path", "core.worktree")
+	if output, err := cmd.Output(); err == nil {
+		workTree := strings.TrimSpace(string(output))
+		if !filepath.IsAbs(workTree) {
+			workTree = filepath.Join(commonDir, workTree)
+		}
+		return resolvePath(workTree), nil
+	}
+	return filepath.Dir(commonDir), nil
+}
 
-	// Not in a worktree, use standard method
-	cmd = exec.Command("git", "rev-parse", "--show-toplevel")
-	output, err = cmd.CombinedOutput()
+// FindCurrentCheckout returns the root of the checkout containing the working
+// directory, which may be the main worktree or a linked worktree
+func FindCurrentCheckout() (string, error) {
+	output, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
 	if err != nil {
-		return "", fmt.Errorf("not a git repository (or any of the parent directories): %w", err)
+		return "", fmt.Errorf("not in a git checkout: %w", err)
 	}
+	return strings.TrimSpace(string(output)), nil
+}
 
-	gitRoot := strings.TrimSpace(string(output))
-	return gitRoot, nil
+// isBareRepository reports whether the repository with the given git directory
+// is bare, meaning it has no main worktree
+func isBareRepository(commonDir string) (bool, error) {
+	cmd := exec.Command("git", "--git-dir", commonDir, "config", "--bool", "core.bare")
+	output, err := cmd.Output()
+	if err != nil {
+		// core.bare isn't set
+		return false, nil
+	}
+	return strings.TrimSpace(string(output)) == "true", nil
+}
+
+// clearGitEnv removes environment variables such as GIT_DIR which would
+// otherwise make the git commands kosho runs in each worktree operate on the
+// repository instead. The repository must be discoverable from gitRoot
+// without them.
+func clearGitEnv(gitRoot string) error {
+	if os.Getenv("GIT_DIR") == "" && os.Getenv("GIT_WORK_TREE") == "" {
+		return nil
+	}
+
+	commonDir, err := GitCommonDir(gitRoot)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"GIT_DIR", "GIT_WORK_TREE", "GIT_COMMON_DIR"} {
+		os.Unsetenv(name)
+	}
+	discovered, err := GitCommonDir(gitRoot)
+	if err != nil || resolvePath(discovered) != resolvePath(commonDir) {
+		return fmt.Errorf("kosho can't manage %s because git only finds it through GIT_DIR", commonDir)
+	}
+	return nil
 }
 
 // RemoveLinesFromGitIgnore removes lines containing the specified substring from a .gitignore file,
@@ -74,6 +133,43 @@ func RemoveLinesFromGitIgnore(gitIgnorePath, substring string) error {
 	return nil
 }
 
+// AppendMissingLinesToGitIgnore appends any lines in expected which are not
+// already present in the .gitignore file. Only writes if changes are needed.
+func AppendMissingLinesToGitIgnore(gitIgnorePath string, expected []byte) error {
+	originalContent, err := os.ReadFile(gitIgnorePath)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for _, line := range strings.Split(string(originalContent), "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+
+	var missing []string
+	for _, line := range strings.Split(string(expected), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !existing[line] {
+			missing = append(missing, line)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	newContent := string(originalContent)
+	if len(newContent) > 0 && !strings.HasSuffix(newContent, "\n") {
+		newContent += "\n"
+	}
+	newContent += strings.Join(missing, "\n") + "\n"
+	if err := os.WriteFile(gitIgnorePath, []byte(newContent), 0644); err != nil {
+		return fmt.Errorf("failed to rewrite .gitignore: %w", err)
+	}
+
+	return nil
+}
+
 func ListBranches(gitRoot string) ([]string, error) {
 	cmd := exec.Command("git", "-C", gitRoot, "branch", "--format=%(refname:short)")
 	output, err := cmd.CombinedOutput()
@@ -92,3 +188,79 @@ func BranchExists(gitRoot string, branchName string) bool {
 	}
 	return true
 }
+
+// GitCommonDir returns the absolute path to the git directory shared by the
+// repository and all of its worktrees
+func GitCommonDir(gitRoot string) (string, error) {
+	cmd := exec.Command("git", "-C", gitRoot, "rev-parse", "--path-format=absolute", "--git-common-dir")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to find git common dir: %w\nOutput: %s", err, string(output))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// GitWorktree is an entry in git's worktree registry
+type GitWorktree struct {
+	Path     string
+	Head     string
+	Branch   string
+	Bare     bool
+	Detached bool
+
+	// Set if the worktree is locked, along with the reason if one was given
+	Locked       bool
+	LockedReason string
+
+	// Set if git would remove the worktree on `git worktree prune`
+	Prunable       bool
+	PrunableReason string
+}
+
+// ListGitWorktrees returns every worktree registered with git, starting with
+// the main worktree
+func ListGitWorktrees(gitRoot string) ([]GitWorktree, error) {
+	cmd := exec.Command("git", "-C", gitRoot, "worktree", "list", "--porcelain", "-z")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list git worktrees: %w", err)
+	}
+
+	var worktrees []GitWorktree
+	var current *GitWorktree
+	for _, field := range strings.Split(string(output), "\x00") {
+		if field == "" {
+			// an empty field terminates each worktree
+			current = nil
+			continue
+		}
+
+		key, value, _ := strings.Cut(field, " ")
+		if key == "worktree" {
+			worktrees = append(worktrees, GitWorktree{Path: value})
+			current = &worktrees[len(worktrees)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		switch key {
+		case "HEAD":
+			current.Head = value
+		case "branch":
+			current.Branch = strings.TrimPrefix(value, "refs/heads/")
+		case "bare":
+			current.Bare = true
+		case "detached":
+			current.Detached = true
+		case "locked":
+			current.Locked = true
+			current.LockedReason = value
+		case "prunable":
+			current.Prunable = true
+			current.PrunableReason = value
+		}
+	}
+	return worktrees, nil
+}
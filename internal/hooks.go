@@ -1,11 +1,18 @@
 package internal
 
 import (
+	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
 type KoshoHook string
@@ -16,14 +23,65 @@ const (
 
 	// Runs before running a command inside a worktree
 	HOOK_RUN KoshoHook = "run"
+
+	// Suffix used for disabled hooks and sample hooks
+	HOOK_SAMPLE_SUFFIX = ".sample"
+)
+
+// KoshoHookTypes lists every hook kosho knows how to run
+var KoshoHookTypes = []KoshoHook{HOOK_CREATE, HOOK_RUN}
+
+// ParseKoshoHook converts a hook name into a KoshoHook, failing if the hook is unknown
+func ParseKoshoHook(name string) (KoshoHook, error) {
+	for _, hook := range KoshoHookTypes {
+		if string(hook) == name {
+			return hook, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hook %q", name)
+}
+
+type HookState string
+
+const (
+	// The hook exists and is executable
+	HOOK_STATE_ENABLED HookState = "enabled"
+
+	// The hook exists but is not executable
+	HOOK_STATE_NOT_EXECUTABLE HookState = "not executable"
+
+	// Only the .sample version of the hook exists
+	HOOK_STATE_DISABLED HookState = "disabled"
+
+	// Neither the hook nor its sample exist
+	HOOK_STATE_MISSING HookState = "missing"
+
+	// The hook exists but the repository has opted out of its scope
+	HOOK_STATE_IGNORED HookState = "ignored"
+)
+
+type HookScope string
+
+const (
+	// Hooks stored in ~/.config/kosho/hooks which apply to every repository
+	HOOK_SCOPE_GLOBAL HookScope = "global"
+
+	// Hooks stored in .kosho/hooks
+	HOOK_SCOPE_REPO HookScope = "repo"
+
+	// Hooks declared in .kosho/config.json
+	HOOK_SCOPE_CONFIG HookScope = "config"
 )
 
+// HookScopes lists every hook scope in the order they run
+var HookScopes = []HookScope{HOOK_SCOPE_GLOBAL, HOOK_SCOPE_REPO, HOOK_SCOPE_CONFIG}
+
 var (
 	//go:embed sample-hooks
 	KoshoHooks embed.FS
 )
 
-func writeKoshoHookSamples(hookDir string) error {
+func writeKoshoHookSamples(hookDir string, overwrite bool) error {
 	samples, err := KoshoHooks.ReadDir("sample-hooks")
 	if err != nil {
 		return fmt.Errorf("failed to read sample hooks directory: %w", err)
@@ -35,7 +93,12 @@ func writeKoshoHookSamples(hookDir string) error {
 		if err != nil {
 			return fmt.Errorf("failed to read sample hook file %s: %w", srcPath, err)
 		}
-		if err := writeFileIfNotExists(destPath, data, 0755); err != nil {
+		if overwrite {
+			err = os.WriteFile(destPath, data, 0755)
+		} else {
+			err = writeFileIfNotExists(destPath, data, 0755)
+		}
+		if err != nil {
 			return fmt.Errorf("failed to write sample hook file %s: %w", destPath, err)
 		}
 	}
@@ -43,10 +106,56 @@ func writeKoshoHookSamples(hookDir string) error {
 	return nil
 }
 
-// RunKoshoHook executes a kosho hook script if it exists, running it in the worktree directory.
+// RunKoshoHook executes the user-global hook, the repository hook and then any
+// matching hooks declared in the kosho config, running them in the worktree
+// directory.
 func RunKoshoHook(worktree *KoshoWorktree, hook KoshoHook, extraEnv ...string) error {
-	hookFile := worktree.KoshoDir.HookPath(hook)
+	for _, scope := range worktree.KoshoDir.ActiveHookScopes() {
+		if scope == HOOK_SCOPE_CONFIG {
+			if err := runConfigHooks(worktree, hook, extraEnv, false); err != nil {
+				return err
+			}
+			continue
+		}
+
+		hookFile, err := worktree.KoshoDir.ScopedHookPath(scope, hook)
+		if err != nil {
+			return err
+		}
+		if err := runKoshoHookFile(worktree, hook, scope, hookFile, extraEnv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunBranchConfigHooks runs only the config hooks which are filtered by branch.
+// This is used when a pooled worktree is claimed, since its branch wasn't known
+// when the create hook ran in the pool.
+func RunBranchConfigHooks(worktree *KoshoWorktree, hook KoshoHook, extraEnv ...string) error {
+	return runConfigHooks(worktree, hook, extraEnv, true)
+}
+
+func runConfigHooks(worktree *KoshoWorktree, hook KoshoHook, extraEnv []string, branchFilteredOnly bool) error {
+	for _, configHook := range worktree.KoshoDir.config.Hooks[hook] {
+		if branchFilteredOnly && len(configHook.Branches) == 0 {
+			continue
+		}
+		if !configHook.MatchesBranch(worktree.BranchName) {
+			continue
+		}
+		if len(configHook.Watch) > 0 {
+			if err := runWatchedConfigHook(worktree, hook, configHook, extraEnv); err != nil {
+				return err
+			}
+		} else if err := runConfigHook(worktree, hook, configHook, extraEnv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func runKoshoHookFile(worktree *KoshoWorktree, hook KoshoHook, scope HookScope, hookFile string, extraEnv []string) error {
 	// abort if hook does not exist
 	if _, err := os.Stat(hookFile); os.IsNotExist(err) {
 		return nil
@@ -59,16 +168,256 @@ func RunKoshoHook(worktree *KoshoWorktree, hook KoshoHook, extraEnv ...string) e
 	cmd.Dir = worktree.WorktreePath()
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	cmd.Env = append(os.Environ(),
+	cmd.Env = append(hookEnv(worktree, hook, scope), extraEnv...)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %s hook %s: %w", scope, hook, err)
+	}
+	return nil
+}
+
+func runConfigHook(worktree *KoshoWorktree, hook KoshoHook, configHook ConfigHook, extraEnv []string) error {
+	ctx := context.Background()
+	if timeout := configHook.TimeoutDuration(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, configHook.Command[0], configHook.Command[1:]...)
+	cmd.Dir = filepath.Join(worktree.WorktreePath(), configHook.Dir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = hookEnv(worktree, hook, HOOK_SCOPE_CONFIG)
+	for key, value := range configHook.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.Env = append(cmd.Env, extraEnv...)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("config hook %s `%s` timed out after %s", hook, configHook.Command[0], configHook.Timeout)
+		}
+		return fmt.Errorf("failed to run config hook %s `%s`: %w", hook, configHook.Command[0], err)
+	}
+	return nil
+}
+
+// runWatchedConfigHook runs a config hook only if the files it watches have
+// changed since its last successful run in this worktree
+func runWatchedConfigHook(worktree *KoshoWorktree, hook KoshoHook, configHook ConfigHook, extraEnv []string) error {
+	digest, err := hashWatchedFiles(worktree.WorktreePath(), configHook.Watch)
+	if err != nil {
+		return fmt.Errorf("failed to hash files watched by config hook %s: %w", hook, err)
+	}
+
+	metadata, err := worktree.LoadMetadata()
+	if err != nil {
+		return err
+	}
+	key, err := configHookKey(hook, configHook)
+	if err != nil {
+		return err
+	}
+	if metadata.WatchHashes[key] == digest {
+		return nil
+	}
+
+	if err := runConfigHook(worktree, hook, configHook, extraEnv); err != nil {
+		return err
+	}
+
+	if metadata.WatchHashes == nil {
+		metadata.WatchHashes = make(map[string]string)
+	}
+	metadata.WatchHashes[key] = digest
+	return worktree.SaveMetadata(metadata)
+}
+
+// configHookKey identifies a config hook by a digest of its whole definition,
+// so that hooks sharing a command but differing in dir, branches or watched
+// files are tracked separately
+func configHookKey(hook KoshoHook, configHook ConfigHook) (string, error) {
+	definition, err := json.Marshal(configHook)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode config hook %s: %w", hook, err)
+	}
+	sum := sha256.Sum256(definition)
+	return string(hook) + ":" + hex.EncodeToString(sum[:8]), nil
+}
+
+// hashWatchedFiles returns a digest of the paths and contents of every file
+// matching the patterns. Directories are hashed recursively.
+func hashWatchedFiles(root string, patterns []string) (string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return "", err
+		}
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.Type().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	slices.Sort(files)
+	files = slices.Compact(files)
+
+	digest := sha256.New()
+	for _, file := range files {
+		relPath, err := filepath.Rel(root, file)
+		if err != nil {
+			return "", err
+		}
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		fileDigest := sha256.Sum256(contents)
+		fmt.Fprintf(digest, "%s %x\n", relPath, fileDigest)
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// hookEnv returns the environment passed to every hook
+func hookEnv(worktree *KoshoWorktree, hook KoshoHook, scope HookScope) []string {
+	return append(os.Environ(),
 		"KOSHO_HOOK="+string(hook),
+		"KOSHO_HOOK_SCOPE="+string(scope),
 		"KOSHO_WORKTREE="+worktree.WorktreeName,
 		"KOSHO_REPO="+worktree.KoshoDir.RepoPath(),
 		"KOSHO_WORKTREE_PATH="+worktree.WorktreePath(),
 	)
-	cmd.Env = append(cmd.Env, extraEnv...)
+}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to run hook %s: %w", hook, err)
+// ActiveHookScopes returns the hook scopes which run in this repository, in
+// the order they run
+func (kr *KoshoDir) ActiveHookScopes() []HookScope {
+	if !kr.config.UseGlobalHooks() {
+		return []HookScope{HOOK_SCOPE_REPO, HOOK_SCOPE_CONFIG}
+	}
+	return HookScopes
+}
+
+// ScopedHookPath returns the path to a hook in the user-global or repository
+// hooks directory. Config hooks return the path to the config file.
+func (kr *KoshoDir) ScopedHookPath(scope HookScope, hook KoshoHook) (string, error) {
+	switch scope {
+	case HOOK_SCOPE_GLOBAL:
+		configDir, err := GlobalConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(configDir, KOSHO_HOOKS_DIR, string(hook)), nil
+	case HOOK_SCOPE_CONFIG:
+		return kr.ConfigPath(), nil
+	}
+	return kr.HookPath(hook), nil
+}
+
+// HookState reports whether a hook is enabled, disabled or missing
+func (kr *KoshoDir) HookState(scope HookScope, hook KoshoHook) (HookState, error) {
+	if scope == HOOK_SCOPE_CONFIG {
+		if len(kr.config.Hooks[hook]) == 0 {
+			return HOOK_STATE_MISSING, nil
+		}
+		return HOOK_STATE_ENABLED, nil
+	}
+
+	hookFile, err := kr.ScopedHookPath(scope, hook)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(hookFile)
+	if err == nil {
+		if !slices.Contains(kr.ActiveHookScopes(), scope) {
+			return HOOK_STATE_IGNORED, nil
+		}
+		if info.Mode().Perm()&0111 == 0 {
+			return HOOK_STATE_NOT_EXECUTABLE, nil
+		}
+		return HOOK_STATE_ENABLED, nil
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to stat hook file %s: %w", hookFile, err)
+	}
+
+	sampleFile := hookFile + HOOK_SAMPLE_SUFFIX
+	if _, err := os.Stat(sampleFile); err == nil {
+		return HOOK_STATE_DISABLED, nil
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to stat hook file %s: %w", sampleFile, err)
+	}
+
+	return HOOK_STATE_MISSING, nil
+}
+
+// EnableHook enables a hook by renaming its .sample file
+func (kr *KoshoDir) EnableHook(scope HookScope, hook KoshoHook) error {
+	if scope == HOOK_SCOPE_CONFIG {
+		return fmt.Errorf("config hooks must be edited in %s", kr.ConfigPath())
+	}
+	hookFile, err := kr.ScopedHookPath(scope, hook)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(hookFile); err == nil {
+		return fmt.Errorf("%s hook %s is already enabled", scope, hook)
+	}
+	if err := os.Rename(hookFile+HOOK_SAMPLE_SUFFIX, hookFile); err != nil {
+		return fmt.Errorf("failed to enable %s hook %s: %w", scope, hook, err)
 	}
 	return nil
 }
+
+// DisableHook disables a hook by renaming it to a .sample file, replacing any
+// existing sample
+func (kr *KoshoDir) DisableHook(scope HookScope, hook KoshoHook) error {
+	if scope == HOOK_SCOPE_CONFIG {
+		return fmt.Errorf("config hooks must be edited in %s", kr.ConfigPath())
+	}
+	hookFile, err := kr.ScopedHookPath(scope, hook)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(hookFile, hookFile+HOOK_SAMPLE_SUFFIX); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s hook %s is not enabled", scope, hook)
+		}
+		return fmt.Errorf("failed to disable %s hook %s: %w", scope, hook, err)
+	}
+	return nil
+}
+
+// UpdateHookSamples rewrites the sample hooks from the ones embedded in kosho
+// and removes samples for hooks that no longer exist
+func (kr *KoshoDir) UpdateHookSamples() error {
+	hookDir := filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_HOOKS_DIR)
+	entries, err := os.ReadDir(hookDir)
+	if err != nil {
+		return fmt.Errorf("failed to read hooks directory: %w", err)
+	}
+	for _, entry := range entries {
+		name, isSample := strings.CutSuffix(entry.Name(), HOOK_SAMPLE_SUFFIX)
+		if !isSample {
+			continue
+		}
+		if _, err := ParseKoshoHook(name); err != nil {
+			if err := os.Remove(filepath.Join(hookDir, entry.Name())); err != nil {
+				return fmt.Errorf("failed to remove stale sample hook %s: %w", entry.Name(), err)
+			}
+		}
+	}
+
+	return writeKoshoHookSamples(hookDir, true)
+}
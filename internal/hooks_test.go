@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEnableDisableHook(t *testing.T) {
+	newTestRepo(t)
+	kr := loadTestKoshoDir(t)
+
+	hookFile, err := kr.ScopedHookPath(HOOK_SCOPE_REPO, HOOK_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		action func(HookScope, KoshoHook) error
+		state  HookState
+		exists string
+	}{
+		{kr.EnableHook, HOOK_STATE_ENABLED, hookFile},
+		{kr.DisableHook, HOOK_STATE_DISABLED, hookFile + HOOK_SAMPLE_SUFFIX},
+		{kr.EnableHook, HOOK_STATE_ENABLED, hookFile},
+	}
+	for i, step := range steps {
+		if err := step.action(HOOK_SCOPE_REPO, HOOK_CREATE); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		state, err := kr.HookState(HOOK_SCOPE_REPO, HOOK_CREATE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != step.state {
+			t.Errorf("step %d: state = %s, want %s", i, state, step.state)
+		}
+		if _, err := os.Stat(step.exists); err != nil {
+			t.Errorf("step %d: %v", i, err)
+		}
+	}
+
+	if err := kr.EnableHook(HOOK_SCOPE_REPO, HOOK_CREATE); err == nil {
+		t.Error("enabling an enabled hook succeeded")
+	}
+}
@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/lithammer/dedent"
@@ -13,6 +15,8 @@ const (
 	KOSHO_DIR          = ".kosho"
 	KOSHO_HOOKS_DIR    = "hooks"
 	KOSHO_WORKTREE_DIR = "worktrees"
+	KOSHO_METADATA_DIR = "metadata"
+	KOSHO_POOL_DIR     = "pool"
 )
 
 var (
@@ -20,41 +24,136 @@ var (
 		/worktrees/
 		/worktrees/**
 		/hooks/*.sample
+		/metadata/
+		/pool/
+		/REPO_ID
 	`), "\n"))
 )
 
 type KoshoDir struct {
+	// repoPath is the main worktree of the repository, or the repository
+	// itself if it's bare
 	repoPath string
+
+	// commonDir is the git directory shared by the repository and all of its
+	// worktrees
+	commonDir string
+
+	config KoshoConfig
+
+	// worktreesDir contains the repository's worktrees, either
+	// .kosho/worktrees or a subdirectory of the configured worktree root
+	worktreesDir string
+
+	// branchTemplate is the configured template for generated branch names
+	branchTemplate string
 }
 
 // LoadKoshoDir creates a new KoshoDir instance and sets up the kosho directory
 // structure if needed.
 func LoadKoshoDir() (*KoshoDir, error) {
+	return loadKoshoDir(true)
+}
+
+// OpenKoshoDir creates a new KoshoDir instance without creating or upgrading
+// the kosho directory structure, so that it can be inspected as is
+func OpenKoshoDir() (*KoshoDir, error) {
+	return loadKoshoDir(false)
+}
+
+func loadKoshoDir(setup bool) (*KoshoDir, error) {
 	repoPath, err := FindGitRoot()
 	if err != nil {
 		return nil, err
 	}
-	err = setupKoshoRepo(repoPath)
+	if err := clearGitEnv(repoPath); err != nil {
+		return nil, err
+	}
+	if err := checkLayoutVersion(repoPath); err != nil {
+		return nil, err
+	}
+	if setup {
+		if err := setupKoshoRepo(repoPath); err != nil {
+			return nil, err
+		}
+	}
+	commonDir, err := GitCommonDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	kr := &KoshoDir{repoPath: repoPath, commonDir: commonDir}
+	kr.config, err = loadKoshoConfig(kr.ConfigPath())
 	if err != nil {
+		return nil, fmt.Errorf("failed to load kosho config: %w", err)
+	}
+	globalConfig, err := loadGlobalKoshoConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load global kosho config: %w", err)
+	}
+	if err := kr.resolveWorktreesDir(globalConfig); err != nil {
 		return nil, err
 	}
-	return &KoshoDir{repoPath: repoPath}, nil
+	kr.branchTemplate = kr.config.BranchTemplate
+	if kr.branchTemplate == "" {
+		kr.branchTemplate = globalConfig.BranchTemplate
+	}
+	if setup {
+		kr.repairBacklinks()
+	}
+	return kr, nil
 }
 
-func setupKoshoRepo(repoDir string) error {
-	// if the root .gitignore contains .kosho, remove it
-	// this is an upgrade step from an earlier Kosho version
-	rootGitIgnorePath := filepath.Join(repoDir, ".gitignore")
-	if err := RemoveLinesFromGitIgnore(rootGitIgnorePath, ".kosho"); err != nil {
-		// Only return error if it's not a "file doesn't exist" error
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove .kosho from .gitignore: %w", err)
+// resolveWorktreesDir decides where the repository's worktrees are stored.
+// The repository config takes precedence over the user-global config.
+func (kr *KoshoDir) resolveWorktreesDir(globalConfig KoshoConfig) error {
+	root := kr.config.WorktreeRoot
+	if root == "" {
+		root = globalConfig.WorktreeRoot
+	}
+	if root == "" {
+		kr.worktreesDir = filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_WORKTREE_DIR)
+		return nil
+	}
+
+	root, err := expandHome(root)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(kr.repoPath, root)
+	}
+	kr.worktreesDir = filepath.Join(root, kr.RepoId())
+	return nil
+}
+
+// KOSHO_REPO_ID_FILE records the repository's id in the .kosho directory
+const KOSHO_REPO_ID_FILE = "REPO_ID"
+
+// RepoId returns a name for the repository which is unique on this machine.
+// It's recorded in .kosho/REPO_ID when the .kosho directory is set up, so
+// that it doesn't change when the repository is moved or mounted elsewhere.
+func (kr *KoshoDir) RepoId() string {
+	idPath := filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_REPO_ID_FILE)
+	if content, err := os.ReadFile(idPath); err == nil {
+		if id := strings.TrimSpace(string(content)); id != "" {
+			return id
 		}
 	}
+	// the directory hasn't been set up yet
+	return pathRepoId(kr.repoPath)
+}
 
+// pathRepoId derives a repository id from the name of the repository
+// directory and a hash of its path
+func pathRepoId(repoPath string) string {
+	hash := sha256.Sum256([]byte(repoPath))
+	return fmt.Sprintf("%s-%x", filepath.Base(repoPath), hash[:4])
+}
+
+func setupKoshoRepo(repoDir string) error {
 	// Create .kosho directory structure
 	koshoDir := filepath.Join(repoDir, KOSHO_DIR)
-	dirs := []string{KOSHO_HOOKS_DIR, KOSHO_WORKTREE_DIR}
+	dirs := []string{KOSHO_HOOKS_DIR, KOSHO_WORKTREE_DIR, KOSHO_METADATA_DIR}
 	for _, dir := range dirs {
 		dirPath := filepath.Join(koshoDir, dir)
 		if err := os.MkdirAll(dirPath, 0755); err != nil {
@@ -63,7 +162,7 @@ func setupKoshoRepo(repoDir string) error {
 	}
 
 	// Initialize the hooks directory with samples
-	if err := writeKoshoHookSamples(filepath.Join(koshoDir, KOSHO_HOOKS_DIR)); err != nil {
+	if err := writeKoshoHookSamples(filepath.Join(koshoDir, KOSHO_HOOKS_DIR), false); err != nil {
 		return fmt.Errorf("failed to initialize hooks directory: %w", err)
 	}
 
@@ -73,7 +172,14 @@ func setupKoshoRepo(repoDir string) error {
 		return fmt.Errorf("failed to create %s: %w", koshoGitIgnorePath, err)
 	}
 
-	return nil
+	// Record the repository id while the repository is at its original path
+	idPath := filepath.Join(koshoDir, KOSHO_REPO_ID_FILE)
+	if err := writeFileIfNotExists(idPath, []byte(pathRepoId(repoDir)+"\n"), 0644); err != nil {
+		return err
+	}
+
+	// Upgrade a .kosho directory created by an earlier Kosho version
+	return migrateKoshoRepo(repoDir)
 }
 
 func writeFileIfNotExists(path string, contents []byte, perm os.FileMode) error {
@@ -92,25 +198,297 @@ func (kr *KoshoDir) RepoPath() string {
 	return kr.repoPath
 }
 
+func (kr *KoshoDir) ConfigPath() string {
+	return filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_CONFIG_FILE)
+}
+
+func (kr *KoshoDir) Config() *KoshoConfig {
+	return &kr.config
+}
+
+func (kr *KoshoDir) WorktreesDir() string {
+	return kr.worktreesDir
+}
+
 func (kr *KoshoDir) WorktreePath(worktreeName string) string {
-	return filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_WORKTREE_DIR, worktreeName)
+	return filepath.Join(kr.worktreesDir, worktreeName)
+}
+
+// PoolDir returns the warm pool directory. When worktrees are stored outside
+// the repository, the pool is stored next to them so claiming a pooled
+// worktree doesn't move it across filesystems.
+func (kr *KoshoDir) PoolDir() string {
+	if kr.worktreesDir != filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_WORKTREE_DIR) {
+		return filepath.Join(kr.worktreesDir, "."+KOSHO_POOL_DIR)
+	}
+	return filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_POOL_DIR)
+}
+
+func (kr *KoshoDir) PoolPath(poolName string) string {
+	return filepath.Join(kr.PoolDir(), poolName)
+}
+
+func (kr *KoshoDir) MetadataPath(worktreeName string) string {
+	return filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_METADATA_DIR, worktreeName+".json")
 }
 
 func (kr *KoshoDir) HookPath(hook KoshoHook) string {
 	return filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_HOOKS_DIR, string(hook))
 }
 
+// ListWorktrees returns the kosho worktrees registered with git, along with
+// any orphaned directories in the worktrees directory, sorted by name.
+// Registered worktrees belong to kosho if they are stored in the worktrees
+// directory or .kosho/worktrees, or if kosho has recorded metadata for them.
 func (kr *KoshoDir) ListWorktrees() ([]KoshoWorktree, error) {
-	entries, err := os.ReadDir(filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_WORKTREE_DIR))
+	gitWorktrees, err := ListGitWorktrees(kr.repoPath)
 	if err != nil {
+		return nil, err
+	}
+
+	worktreesDir := resolvePath(kr.worktreesDir)
+	defaultDir := resolvePath(filepath.Join(kr.repoPath, KOSHO_DIR, KOSHO_WORKTREE_DIR))
+	poolDir := resolvePath(kr.PoolDir())
+
+	// worktrees whose links from the repository couldn't be repaired after
+	// the repository moved are registered at their old location
+	relocated := make(map[string]string)
+	for _, stale := range kr.staleBacklinks() {
+		relocated[filepath.Dir(filepath.Clean(stale.backlink))] = stale.worktreePath
+	}
+
+	var worktrees []KoshoWorktree
+	registered := make(map[string]bool)
+	for i, gitWorktree := range gitWorktrees {
+		// the first worktree is the main worktree
+		if i == 0 || gitWorktree.Bare {
+			continue
+		}
+		if path, ok := relocated[filepath.Clean(gitWorktree.Path)]; ok && gitWorktree.Prunable {
+			gitWorktree.Path = path
+			gitWorktree.Prunable = false
+			gitWorktree.PrunableReason = ""
+		}
+
+		name := filepath.Base(gitWorktree.Path)
+		parent := resolvePath(filepath.Dir(gitWorktree.Path))
+		if parent == poolDir {
+			continue
+		}
+
+		// worktrees stored elsewhere belong to kosho if it has recorded
+		// metadata for them, and for worktrees adopted in place, if they are
+		// still stored where they were adopted from
+		metadata := kr.loadMetadata(name)
+		if parent != worktreesDir && parent != defaultDir {
+			if metadata == nil {
+				continue
+			}
+			if metadata.AdoptedInPlace && resolvePath(metadata.AdoptedFrom) != resolvePath(gitWorktree.Path) {
+				continue
+			}
+		}
+		registered[name] = parent == worktreesDir
+
+		kw := KoshoWorktree{KoshoDir: *kr, BranchName: gitWorktree.Branch, WorktreeName: name, State: WORKTREE_OK}
+		if kw.BranchName == "" && metadata != nil {
+			kw.BranchName = metadata.Branch
+			kw.DetachedRef = metadata.Detached
+		}
+		if parent != worktreesDir {
+			kw.path = gitWorktree.Path
+			kw.State = WORKTREE_MOVED
+			kw.StateReason = gitWorktree.Path
+			if metadata != nil && metadata.AdoptedInPlace {
+				kw.State = WORKTREE_ADOPTED
+			}
+		}
+
+		switch {
+		case gitWorktree.Prunable:
+			kw.State = WORKTREE_PRUNABLE
+			kw.StateReason = gitWorktree.PrunableReason
+		case gitWorktree.Locked:
+			kw.State = WORKTREE_LOCKED
+			kw.StateReason = gitWorktree.LockedReason
+		}
+		worktrees = append(worktrees, kw)
+	}
+
+	entries, err := os.ReadDir(kr.worktreesDir)
+	if err != nil && !os.IsNotExist(err) {
 		return nil, fmt.Errorf("failed to read directory: %w", err)
 	}
-	worktrees := make([]KoshoWorktree, 0, len(entries))
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		// slugs never start with a dot, which leaves room for the pool
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || registered[entry.Name()] {
 			continue
 		}
-		worktrees = append(worktrees, *NewKoshoWorktree(*kr, entry.Name()))
+		worktrees = append(worktrees, KoshoWorktree{
+			KoshoDir:     *kr,
+			BranchName:   entry.Name(),
+			WorktreeName: entry.Name(),
+			State:        WORKTREE_ORPHAN,
+			StateReason:  "not registered with git",
+		})
 	}
+
+	slices.SortFunc(worktrees, func(a, b KoshoWorktree) int {
+		return strings.Compare(a.WorktreeName, b.WorktreeName)
+	})
 	return worktrees, nil
 }
+
+// FindWorktree returns the kosho worktree with the given name
+func (kr *KoshoDir) FindWorktree(name string) (*KoshoWorktree, error) {
+	worktrees, err := kr.ListWorktrees()
+	if err != nil {
+		return nil, err
+	}
+	for _, kw := range worktrees {
+		if kw.Name() == name {
+			return &kw, nil
+		}
+	}
+	return nil, fmt.Errorf("worktree '%s' not found", name)
+}
+
+// FindNamedWorktree returns the worktree named name, falling back to the
+// worktree of the branch name for convenience
+func (kr *KoshoDir) FindNamedWorktree(name string) (*KoshoWorktree, error) {
+	if kw, err := kr.FindWorktree(name); err == nil {
+		return kw, nil
+	}
+	return kr.FindBranchWorktree(name)
+}
+
+// worktreeOwner is the branch, or the ref of a detached worktree, that a
+// worktree name is recorded for
+type worktreeOwner struct {
+	ref      string
+	detached bool
+}
+
+func (o worktreeOwner) String() string {
+	if o.detached {
+		return fmt.Sprintf("detached %s", o.ref)
+	}
+	return fmt.Sprintf("branch %s", o.ref)
+}
+
+// ResolveWorktree returns the worktree for branch. Existing worktrees are
+// found by the branch recorded in their metadata, falling back to the branch
+// git reports for them. Otherwise a new worktree is returned, named name if it
+// isn't empty, or after the branch with a numeric suffix if another branch's
+// worktree already has that name.
+func (kr *KoshoDir) ResolveWorktree(branch, name string) (*KoshoWorktree, error) {
+	return kr.resolveWorktree(worktreeOwner{ref: branch}, name)
+}
+
+// ResolveDetachedWorktree returns the worktree with a detached HEAD created at
+// ref, or a new one named like ResolveWorktree does
+func (kr *KoshoDir) ResolveDetachedWorktree(ref, name string) (*KoshoWorktree, error) {
+	return kr.resolveWorktree(worktreeOwner{ref: ref, detached: true}, name)
+}
+
+func (kr *KoshoDir) resolveWorktree(owner worktreeOwner, name string) (*KoshoWorktree, error) {
+	if name != "" && sluggify(name) != name {
+		return nil, fmt.Errorf("invalid worktree name %q, names may only contain lowercase letters, numbers and dashes", name)
+	}
+
+	worktrees, err := kr.ListWorktrees()
+	if err != nil {
+		return nil, err
+	}
+
+	// the branch or detached ref each existing worktree name is recorded for
+	owners := make(map[string]worktreeOwner)
+	for _, kw := range worktrees {
+		recorded := worktreeOwner{ref: kw.BranchName}
+		if kw.State == WORKTREE_ORPHAN {
+			// orphans are named after their directory rather than a branch
+			recorded.ref = ""
+		}
+		if metadata := kr.loadMetadata(kw.Name()); metadata != nil {
+			if metadata.Branch != "" {
+				recorded = worktreeOwner{ref: metadata.Branch}
+			} else if metadata.Detached != "" {
+				recorded = worktreeOwner{ref: metadata.Detached, detached: true}
+			}
+		}
+		owners[kw.Name()] = recorded
+	}
+
+	newWorktree := func(name string) *KoshoWorktree {
+		if owner.detached {
+			return &KoshoWorktree{KoshoDir: *kr, DetachedRef: owner.ref, WorktreeName: name}
+		}
+		return &KoshoWorktree{KoshoDir: *kr, BranchName: owner.ref, WorktreeName: name}
+	}
+
+	for _, kw := range worktrees {
+		if owners[kw.Name()] != owner || owner.ref == "" {
+			continue
+		}
+		if name != "" && name != kw.Name() {
+			return nil, fmt.Errorf("%s already has worktree '%s'", owner, kw.Name())
+		}
+		if owner.detached {
+			kw.DetachedRef = owner.ref
+		} else {
+			kw.BranchName = owner.ref
+		}
+		return &kw, nil
+	}
+
+	if name != "" {
+		if other, taken := owners[name]; taken {
+			return nil, fmt.Errorf("worktree '%s' belongs to %s", name, other)
+		}
+		return newWorktree(name), nil
+	}
+
+	return newWorktree(uniqueWorktreeName(owner.ref, owners)), nil
+}
+
+// uniqueWorktreeName returns the default worktree name for ref, with a
+// numeric suffix if another branch's worktree already has that name
+func uniqueWorktreeName(ref string, owners map[string]worktreeOwner) string {
+	name := DefaultWorktreeName(ref)
+	for i := 2; ; i++ {
+		if _, taken := owners[name]; !taken {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", DefaultWorktreeName(ref), i)
+	}
+}
+
+// FindBranchWorktree returns the existing worktree for branch, or the
+// detached worktree created at branch if there is no worktree for it. If
+// neither exists, branch is looked up as a worktree name, so that worktrees
+// named with --name or a numeric suffix can be found by their name.
+func (kr *KoshoDir) FindBranchWorktree(branch string) (*KoshoWorktree, error) {
+	kw, err := kr.ResolveWorktree(branch, "")
+	if err != nil {
+		return nil, err
+	}
+	if kw.State == "" {
+		if detached, err := kr.ResolveDetachedWorktree(branch, ""); err == nil && detached.State != "" {
+			return detached, nil
+		}
+		if named, err := kr.FindWorktree(branch); err == nil {
+			return named, nil
+		}
+		return nil, fmt.Errorf("no worktree is named %s or checked out to it", branch)
+	}
+	return kw, nil
+}
+
+// resolvePath resolves any symlinks in path so that it can be compared with
+// the paths reported by git
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
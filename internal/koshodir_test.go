@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRepo creates a git repository with one commit, isolated from the
+// user's git and kosho config, and makes it the working directory
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "kosho")
+	t.Setenv("GIT_AUTHOR_EMAIL", "kosho@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "kosho")
+	t.Setenv("GIT_COMMITTER_EMAIL", "kosho@example.com")
+
+	repo := resolvePath(t.TempDir())
+	runGit(t, repo, "init", "--quiet", "--initial-branch=main")
+	runGit(t, repo, "commit", "--quiet", "--allow-empty", "-m", "initial")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(repo); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return repo
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, output)
+	}
+	return string(output)
+}
+
+func loadTestKoshoDir(t *testing.T) *KoshoDir {
+	t.Helper()
+	kr, err := LoadKoshoDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestUniqueWorktreeName(t *testing.T) {
+	tests := []struct {
+		ref    string
+		owners map[string]worktreeOwner
+		want   string
+	}{
+		{"feat/a", nil, "feat-a"},
+		{"feat/a", map[string]worktreeOwner{"feat-a": {ref: "feat-a"}}, "feat-a-2"},
+		{"feat/a", map[string]worktreeOwner{
+			"feat-a":   {ref: "feat-a"},
+			"feat-a-2": {ref: "feat_a"},
+		}, "feat-a-3"},
+		{"v1.2", map[string]worktreeOwner{"v1-2": {ref: "v1.2", detached: true}}, "v1-2-2"},
+	}
+	for _, tt := range tests {
+		if got := uniqueWorktreeName(tt.ref, tt.owners); got != tt.want {
+			t.Errorf("uniqueWorktreeName(%q, %v) = %q, want %q", tt.ref, tt.owners, got, tt.want)
+		}
+	}
+}
+
+func TestFindBranchWorktree(t *testing.T) {
+	newTestRepo(t)
+	kr := loadTestKoshoDir(t)
+
+	for _, branch := range []string{"feat-a", "feat/a"} {
+		kw, err := kr.ResolveWorktree(branch, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := kw.CreateWorktree(CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	named, err := kr.ResolveWorktree("feat/b", "bee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := named.CreateWorktree(CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		lookup string
+		name   string
+		branch string
+	}{
+		{"feat-a", "feat-a", "feat-a"},
+		{"feat/a", "feat-a-2", "feat/a"},
+		{"feat-a-2", "feat-a-2", "feat/a"},
+		{"feat/b", "bee", "feat/b"},
+		{"bee", "bee", "feat/b"},
+	}
+	for _, tt := range tests {
+		kw, err := kr.FindBranchWorktree(tt.lookup)
+		if err != nil {
+			t.Errorf("FindBranchWorktree(%q) failed: %v", tt.lookup, err)
+			continue
+		}
+		if kw.Name() != tt.name || kw.BranchName != tt.branch {
+			t.Errorf("FindBranchWorktree(%q) = worktree '%s' on %s, want '%s' on %s", tt.lookup, kw.Name(), kw.BranchName, tt.name, tt.branch)
+		}
+	}
+
+	if _, err := kr.FindBranchWorktree("missing"); err == nil {
+		t.Errorf("FindBranchWorktree(%q) found a worktree", "missing")
+	}
+}
+
+func TestRepoIdSurvivesMove(t *testing.T) {
+	repo := t.TempDir()
+	if err := setupKoshoRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	id := (&KoshoDir{repoPath: repo}).RepoId()
+	if id != pathRepoId(repo) {
+		t.Errorf("RepoId() = %q, want %q", id, pathRepoId(repo))
+	}
+
+	moved := filepath.Join(t.TempDir(), "moved")
+	if err := os.Rename(repo, moved); err != nil {
+		t.Fatal(err)
+	}
+	if got := (&KoshoDir{repoPath: moved}).RepoId(); got != id {
+		t.Errorf("RepoId() after moving = %q, want %q", got, id)
+	}
+	if err := setupKoshoRepo(moved); err != nil {
+		t.Fatal(err)
+	}
+	if got := (&KoshoDir{repoPath: moved}).RepoId(); got != id {
+		t.Errorf("RepoId() after setting up the moved repository = %q, want %q", got, id)
+	}
+}
@@ -0,0 +1,318 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// worktreeCmd returns a `git worktree` command which links the worktree and
+// the repository with relative paths, so that they survive moving the
+// repository or mounting it at a different path. Git 2.48 and later support
+// this natively, older versions ignore the setting and kosho makes the
+// worktree's .git file relative itself, see relativizeGitLink.
+func worktreeCmd(args ...string) *exec.Cmd {
+	return exec.Command("git", append([]string{"-c", "worktree.useRelativePaths=true", "worktree"}, args...)...)
+}
+
+// gitSupportsRelativePaths reports whether git can record the link from the
+// repository back to each worktree as a relative path
+func gitSupportsRelativePaths() bool {
+	output, err := exec.Command("git", "version").Output()
+	if err != nil {
+		return false
+	}
+	// git version 2.48.1
+	fields := strings.Fields(string(output))
+	if len(fields) < 3 {
+		return false
+	}
+	parts := strings.SplitN(fields[2], ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	return major > 2 || (major == 2 && minor >= 48)
+}
+
+// readGitLink returns the git directory the worktree's .git file points to,
+// as written in the file. Returns false if the worktree has no .git file.
+func readGitLink(worktreePath string) (string, bool, error) {
+	dotGit := filepath.Join(worktreePath, ".git")
+	content, err := os.ReadFile(dotGit)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		// .git is a directory in the main worktree
+		if info, statErr := os.Stat(dotGit); statErr == nil && info.IsDir() {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to read %s: %w", dotGit, err)
+	}
+
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir: ")
+	return gitDir, ok, nil
+}
+
+// resolveGitLink returns the absolute git directory the worktree's .git file
+// points to
+func resolveGitLink(worktreePath string) (string, bool, error) {
+	gitDir, ok, err := readGitLink(worktreePath)
+	if !ok || err != nil {
+		return "", ok, err
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(worktreePath, gitDir)
+	}
+	return gitDir, true, nil
+}
+
+// readBacklink returns the path of the worktree's .git file recorded in the
+// repository's git directory for the worktree, as written in the file
+func readBacklink(gitDir string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(gitDir, "gitdir"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// resolveBacklink returns the absolute path of the worktree's .git file
+// recorded in the repository's git directory for the worktree
+func resolveBacklink(gitDir string) (string, error) {
+	backlink, err := readBacklink(gitDir)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(backlink) {
+		backlink = filepath.Join(gitDir, backlink)
+	}
+	return backlink, nil
+}
+
+// relativizeGitLink rewrites the worktree's .git file to point to the
+// repository with a relative path. Git resolves relative paths in .git files
+// against the directory containing them in every version.
+func relativizeGitLink(worktreePath string) error {
+	gitDir, ok, err := readGitLink(worktreePath)
+	if err != nil || !ok || !filepath.IsAbs(gitDir) {
+		return err
+	}
+	return writeGitLink(worktreePath, gitDir)
+}
+
+// writeGitLink points the .git file at worktreePath to the absolute git
+// directory gitDir with a relative path
+func writeGitLink(worktreePath, gitDir string) error {
+	rel, err := filepath.Rel(resolvePath(worktreePath), resolvePath(gitDir))
+	if err != nil {
+		return fmt.Errorf("failed to make %s relative: %w", gitDir, err)
+	}
+	dotGit := filepath.Join(worktreePath, ".git")
+	if err := os.WriteFile(dotGit, []byte("gitdir: "+rel+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to rewrite %s: %w", dotGit, err)
+	}
+	return nil
+}
+
+// moveWorktree moves the worktree at oldPath to newPath with a relative .git
+// file, moving it back if the link can't be rewritten
+func moveWorktree(repoPath, oldPath, newPath string) error {
+	submodules, err := submoduleLinks(oldPath)
+	if err != nil {
+		return err
+	}
+	if len(submodules) > 0 {
+		return moveSubmoduleWorktree(repoPath, oldPath, newPath, submodules)
+	}
+
+	if err := gitMoveWorktree(repoPath, oldPath, newPath); err != nil {
+		return err
+	}
+	if err := relativizeGitLink(newPath); err != nil {
+		if undoErr := gitMoveWorktree(repoPath, newPath, oldPath); undoErr != nil {
+			return fmt.Errorf("%w (failed to move worktree back: %w)", err, undoErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func gitMoveWorktree(repoPath, oldPath, newPath string) error {
+	cmd := worktreeCmd("move", oldPath, newPath)
+	cmd.Dir = repoPath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to move worktree: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
+// moveSubmoduleWorktree moves a worktree containing initialized submodules,
+// which `git worktree move` refuses to do because the links between the
+// submodules and their git directories would go stale. The directory is
+// renamed and every link is rewritten, moving it back if that fails.
+func moveSubmoduleWorktree(repoPath, oldPath, newPath string, submodules []submoduleLink) error {
+	gitDir, ok, err := resolveGitLink(oldPath)
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("%s is not a linked worktree", oldPath)
+	}
+	gitDir = resolvePath(gitDir)
+	if _, err := os.Stat(filepath.Join(gitDir, "locked")); err == nil {
+		return fmt.Errorf("worktree %s is locked", oldPath)
+	}
+	if _, err := os.Lstat(newPath); err == nil {
+		return fmt.Errorf("%s already exists", newPath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(newPath), err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to move worktree: %w", err)
+	}
+	if err := connectWorktree(repoPath, newPath, gitDir, submodules); err != nil {
+		undoErr := os.Rename(newPath, oldPath)
+		if undoErr == nil {
+			undoErr = connectWorktree(repoPath, oldPath, gitDir, submodules)
+		}
+		if undoErr != nil {
+			return fmt.Errorf("%w (failed to move worktree back: %w)", err, undoErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// connectWorktree rewrites the links between the worktree at worktreePath,
+// its submodules and the repository after the worktree was moved there
+func connectWorktree(repoPath, worktreePath, gitDir string, submodules []submoduleLink) error {
+	if err := writeGitLink(worktreePath, gitDir); err != nil {
+		return err
+	}
+	cmd := worktreeCmd("repair", worktreePath)
+	cmd.Dir = repoPath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to repair worktree: %w\nOutput: %s", err, string(output))
+	}
+	for _, submodule := range submodules {
+		if err := submodule.connect(worktreePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staleBacklink is a worktree whose link from the repository points to where
+// its .git file used to be
+type staleBacklink struct {
+	worktreePath string
+	gitDir       string
+
+	// the .git file the repository links to, which no longer exists
+	backlink string
+}
+
+// staleBacklinks finds the worktrees in the worktrees and pool directories
+// whose .git files still resolve to the repository, but whose links back from
+// the repository point to a .git file which no longer exists. Git versions
+// without relative path support record these links as absolute paths, so they
+// go stale whenever the repository is moved or mounted at a different path.
+// Links to .git files which still exist are left alone, since the worktree
+// may be a copy of another one.
+func (kr *KoshoDir) staleBacklinks() []staleBacklink {
+	registrations := resolvePath(filepath.Join(kr.commonDir, "worktrees"))
+
+	var stale []staleBacklink
+	for _, dir := range []string{kr.worktreesDir, kr.PoolDir()} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			worktreePath := filepath.Join(dir, entry.Name())
+			gitDir, ok, err := resolveGitLink(worktreePath)
+			if err != nil || !ok || resolvePath(filepath.Dir(gitDir)) != registrations {
+				continue
+			}
+			backlink, err := resolveBacklink(gitDir)
+			if err != nil || resolvePath(backlink) == resolvePath(filepath.Join(worktreePath, ".git")) {
+				continue
+			}
+			if _, err := os.Lstat(backlink); err == nil {
+				continue
+			}
+			stale = append(stale, staleBacklink{worktreePath: worktreePath, gitDir: gitDir, backlink: backlink})
+		}
+	}
+	return stale
+}
+
+// repairBacklinks points stale links from the repository back to each
+// worktree at the worktree's current location, so that git doesn't consider
+// the worktrees prunable. This is best effort: when the repository can't be
+// written, such as on a read-only mount, ListWorktrees still finds the
+// worktrees at their current location, and `kosho doctor` reports them.
+func (kr *KoshoDir) repairBacklinks() {
+	for _, stale := range kr.staleBacklinks() {
+		err := stale.repair()
+		if err != nil && !errors.Is(err, fs.ErrPermission) && !errors.Is(err, syscall.EROFS) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+func (stale *staleBacklink) repair() error {
+	dotGit := resolvePath(filepath.Join(stale.worktreePath, ".git"))
+
+	// keep the link relative if git wrote it that way
+	newBacklink := dotGit
+	if backlink, err := readBacklink(stale.gitDir); err == nil && !filepath.IsAbs(backlink) {
+		rel, err := filepath.Rel(resolvePath(stale.gitDir), dotGit)
+		if err != nil {
+			return fmt.Errorf("failed to make %s relative: %w", dotGit, err)
+		}
+		newBacklink = rel
+	}
+
+	backlinkPath := filepath.Join(stale.gitDir, "gitdir")
+	if err := os.WriteFile(backlinkPath, []byte(newBacklink+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to rewrite %s: %w", backlinkPath, err)
+	}
+	return nil
+}
+
+// gitLinkBroken reports whether the worktree's .git file points to a
+// directory which doesn't exist or doesn't belong to the repository, or
+// whose link back to the worktree points elsewhere
+func gitLinkBroken(worktreePath, commonDir string) (bool, error) {
+	gitDir, ok, err := resolveGitLink(worktreePath)
+	if err != nil || !ok {
+		return false, err
+	}
+	if resolvePath(filepath.Dir(gitDir)) != resolvePath(filepath.Join(commonDir, "worktrees")) {
+		return true, nil
+	}
+	backlink, err := resolveBacklink(gitDir)
+	if err != nil {
+		return true, nil
+	}
+	return resolvePath(backlink) != resolvePath(filepath.Join(worktreePath, ".git")), nil
+}
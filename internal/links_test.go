@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMovedRepository(t *testing.T) {
+	repo := newTestRepo(t)
+	kr := loadTestKoshoDir(t)
+	kw, err := kr.ResolveWorktree("feat/a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kw.CreateWorktree(CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	moved := filepath.Join(resolvePath(t.TempDir()), "moved")
+	if err := os.Rename(repo, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(moved); err != nil {
+		t.Fatal(err)
+	}
+	movedPath := filepath.Join(moved, KOSHO_DIR, KOSHO_WORKTREE_DIR, "feat-a")
+
+	// without repairing anything, as on a read-only mount, the worktree is
+	// found at its new location
+	opened, err := OpenKoshoDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktrees, err := opened.ListWorktrees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(worktrees) != 1 {
+		t.Fatalf("found %d worktrees, want 1: %v", len(worktrees), worktrees)
+	}
+	if worktrees[0].State != WORKTREE_OK || worktrees[0].WorktreePath() != movedPath {
+		t.Errorf("worktree is %s at %s, want %s at %s", worktrees[0].State, worktrees[0].WorktreePath(), WORKTREE_OK, movedPath)
+	}
+	found, err := opened.FindBranchWorktree("feat/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Name() != "feat-a" {
+		t.Errorf("found worktree '%s' for feat/a, want 'feat-a'", found.Name())
+	}
+
+	// loading the kosho directory repairs the links, so git agrees
+	loadTestKoshoDir(t)
+	output := runGit(t, moved, "worktree", "list", "--porcelain")
+	if strings.Contains(output, "prunable") || !strings.Contains(output, "worktree "+movedPath+"\n") {
+		t.Errorf("git still lists the worktree at its old location:\n%s", output)
+	}
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MisplacedWorktree is a kosho worktree which is registered with git but not
+// stored in the configured worktrees directory
+type MisplacedWorktree struct {
+	Worktree KoshoWorktree
+	Path     string
+}
+
+// MisplacedWorktrees finds kosho worktrees stored outside the configured
+// worktrees directory, such as worktrees created before the worktree root was
+// changed
+func (kr *KoshoDir) MisplacedWorktrees() ([]MisplacedWorktree, error) {
+	worktrees, err := kr.ListWorktrees()
+	if err != nil {
+		return nil, err
+	}
+
+	var misplaced []MisplacedWorktree
+	for _, kw := range worktrees {
+		if kw.State != WORKTREE_MOVED {
+			continue
+		}
+		path := kw.WorktreePath()
+		kw.path = ""
+		misplaced = append(misplaced, MisplacedWorktree{Worktree: kw, Path: path})
+	}
+	return misplaced, nil
+}
+
+// MoveFrom moves the worktree from oldPath to its configured location
+func (kw *KoshoWorktree) MoveFrom(oldPath string) error {
+	if exists, err := kw.Exists(); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("%s already exists", kw.WorktreePath())
+	}
+
+	if err := os.MkdirAll(filepath.Dir(kw.WorktreePath()), 0755); err != nil {
+		return fmt.Errorf("failed to create worktrees directory: %w", err)
+	}
+
+	if err := moveWorktree(kw.KoshoDir.RepoPath(), oldPath, kw.WorktreePath()); err != nil {
+		return err
+	}
+
+	// worktrees created by earlier versions of kosho have no metadata, which
+	// is how they are recognized outside of .kosho/worktrees
+	metadata, err := kw.LoadMetadata()
+	if err != nil {
+		return err
+	}
+	if metadata.Branch == "" {
+		if metadata.Branch, err = kw.GitBranch(); err != nil {
+			metadata.Branch = kw.BranchName
+		}
+	}
+	return kw.SaveMetadata(metadata)
+}
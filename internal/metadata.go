@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// WorktreeMetadata is the state kosho records about each worktree, stored in
+// .kosho/metadata/NAME.json
+type WorktreeMetadata struct {
+	// Digest of the files watched by each config hook as of its last
+	// successful run, keyed by the hook type and a digest of its definition
+	WatchHashes map[string]string `json:"watch_hashes,omitempty"`
+
+	// The branch the worktree was created for
+	Branch string `json:"branch,omitempty"`
+
+	// The ref and commit a worktree with a detached HEAD was created at
+	Detached       string `json:"detached,omitempty"`
+	DetachedCommit string `json:"detached_commit,omitempty"`
+
+	// The pid of the `kosho tmp` process which removes the worktree once its
+	// command exits
+	TmpPid int `json:"tmp_pid,omitempty"`
+
+	// Directories checked out in a sparse worktree, empty for a full checkout
+	Sparse []string `json:"sparse,omitempty"`
+
+	// Where a worktree adopted with `kosho adopt` was stored when it was
+	// adopted, and whether it was left there rather than moved into the
+	// worktrees directory
+	AdoptedFrom    string `json:"adopted_from,omitempty"`
+	AdoptedInPlace bool   `json:"adopted_in_place,omitempty"`
+
+	// The branch and commit of the worktree this worktree was forked from
+	// with `kosho fork`
+	Parent       string `json:"parent,omitempty"`
+	ParentCommit string `json:"parent_commit,omitempty"`
+}
+
+// LoadMetadata reads the worktree's metadata, returning empty metadata if none
+// has been recorded
+func (kw *KoshoWorktree) LoadMetadata() (*WorktreeMetadata, error) {
+	metadataPath := kw.metadataPath()
+
+	var metadata WorktreeMetadata
+	data, err := os.ReadFile(metadataPath)
+	if os.IsNotExist(err) {
+		return &metadata, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read worktree metadata: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", metadataPath, err)
+	}
+	return &metadata, nil
+}
+
+// loadMetadata reads the metadata recorded for a worktree, returning nil if
+// there is none
+func (kr *KoshoDir) loadMetadata(worktreeName string) *WorktreeMetadata {
+	kw := KoshoWorktree{KoshoDir: *kr, WorktreeName: worktreeName}
+	if _, err := os.Stat(kw.metadataPath()); err != nil {
+		return nil
+	}
+	metadata, err := kw.LoadMetadata()
+	if err != nil {
+		return nil
+	}
+	return metadata
+}
+
+// SaveMetadata writes the worktree's metadata
+func (kw *KoshoWorktree) SaveMetadata(metadata *WorktreeMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode worktree metadata: %w", err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(kw.metadataPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write worktree metadata: %w", err)
+	}
+	return nil
+}
+
+// RemoveMetadata deletes the worktree's metadata if it exists
+func (kw *KoshoWorktree) RemoveMetadata() error {
+	err := os.Remove(kw.metadataPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove worktree metadata: %w", err)
+	}
+	return nil
+}
+
+func (kw *KoshoWorktree) metadataPath() string {
+	if kw.pooled {
+		return kw.KoshoDir.PoolPath(kw.WorktreeName) + ".json"
+	}
+	return kw.KoshoDir.MetadataPath(kw.WorktreeName)
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const KOSHO_VERSION_FILE = "VERSION"
+
+// migration upgrades the .kosho directory from the previous layout version
+type migration struct {
+	description string
+	migrate     func(repoDir string) error
+}
+
+// migrations upgrade the .kosho directory layout in order. Layout version N
+// is the layout after the first N migrations have run, so migrations must
+// only ever be appended to this list, and must not depend on values which
+// change between versions, such as KoshoGitIgnore.
+var migrations = []migration{
+	{
+		description: "remove .kosho from the root .gitignore",
+		migrate: func(repoDir string) error {
+			rootGitIgnorePath := filepath.Join(repoDir, ".gitignore")
+			err := RemoveLinesFromGitIgnore(rootGitIgnorePath, ".kosho")
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		},
+	},
+	{
+		description: "ignore the metadata and pool directories in .kosho/.gitignore",
+		migrate: func(repoDir string) error {
+			return AppendMissingLinesToGitIgnore(filepath.Join(repoDir, KOSHO_DIR, ".gitignore"), []byte("/metadata/\n/pool/\n"))
+		},
+	},
+	{
+		description: "ignore the repository id in .kosho/.gitignore",
+		migrate: func(repoDir string) error {
+			return AppendMissingLinesToGitIgnore(filepath.Join(repoDir, KOSHO_DIR, ".gitignore"), []byte("/REPO_ID\n"))
+		},
+	},
+}
+
+// KoshoLayoutVersion is the newest .kosho layout this version of kosho
+// understands
+func KoshoLayoutVersion() int {
+	return len(migrations)
+}
+
+// readLayoutVersion returns the layout version recorded in .kosho/VERSION.
+// Directories created before the layout was versioned have no VERSION file
+// and are at version 0.
+func readLayoutVersion(repoDir string) (int, error) {
+	versionPath := filepath.Join(repoDir, KOSHO_DIR, KOSHO_VERSION_FILE)
+	content, err := os.ReadFile(versionPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", versionPath, err)
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid layout version in %s: %q", versionPath, strings.TrimSpace(string(content)))
+	}
+	return version, nil
+}
+
+func writeLayoutVersion(repoDir string, version int) error {
+	versionPath := filepath.Join(repoDir, KOSHO_DIR, KOSHO_VERSION_FILE)
+	if err := os.WriteFile(versionPath, []byte(strconv.Itoa(version)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", versionPath, err)
+	}
+	return nil
+}
+
+// checkLayoutVersion refuses to work with a .kosho directory created by a
+// newer version of kosho
+func checkLayoutVersion(repoDir string) error {
+	version, err := readLayoutVersion(repoDir)
+	if err != nil {
+		return err
+	}
+	if version > KoshoLayoutVersion() {
+		return fmt.Errorf("%s uses layout version %d, but this version of kosho only understands up to version %d, please upgrade kosho",
+			filepath.Join(repoDir, KOSHO_DIR), version, KoshoLayoutVersion())
+	}
+	return nil
+}
+
+// migrateKoshoRepo runs the migrations which haven't been applied to the
+// .kosho directory yet, recording the layout version after each one
+func migrateKoshoRepo(repoDir string) error {
+	version, err := readLayoutVersion(repoDir)
+	if err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		m := migrations[version]
+		if err := m.migrate(repoDir); err != nil {
+			return fmt.Errorf("failed to migrate .kosho to version %d (%s): %w", version+1, m.description, err)
+		}
+		if err := writeLayoutVersion(repoDir, version+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateKoshoRepo(t *testing.T) {
+	tests := []struct {
+		name          string
+		version       string
+		rootGitIgnore string
+		koshoIgnore   string
+		wantRoot      string
+		wantKosho     string
+	}{
+		{
+			name:          "unversioned",
+			rootGitIgnore: "node_modules\n.kosho\n",
+			koshoIgnore:   "/worktrees/\n/worktrees/**\n/hooks/*.sample\n",
+			wantRoot:      "node_modules\n",
+			wantKosho:     "/worktrees/\n/worktrees/**\n/hooks/*.sample\n/metadata/\n/pool/\n/REPO_ID\n",
+		},
+		{
+			name:        "version 1 without a root .gitignore",
+			version:     "1\n",
+			koshoIgnore: "/worktrees/\n# custom\n/metadata/",
+			wantKosho:   "/worktrees/\n# custom\n/metadata/\n/pool/\n/REPO_ID\n",
+		},
+		{
+			name:          "version 2 only gets the repository id",
+			version:       "2\n",
+			rootGitIgnore: ".kosho\n",
+			koshoIgnore:   "/worktrees/\n",
+			wantRoot:      ".kosho\n",
+			wantKosho:     "/worktrees/\n/REPO_ID\n",
+		},
+		{
+			name:        "version 3 is left alone",
+			version:     "3\n",
+			koshoIgnore: "/worktrees/\n",
+			wantKosho:   "/worktrees/\n",
+		},
+	}
+	for _, tt := range tests {
+		repo := t.TempDir()
+		koshoDir := filepath.Join(repo, KOSHO_DIR)
+		writeTestFile(t, filepath.Join(koshoDir, ".gitignore"), tt.koshoIgnore)
+		if tt.rootGitIgnore != "" {
+			writeTestFile(t, filepath.Join(repo, ".gitignore"), tt.rootGitIgnore)
+		}
+		if tt.version != "" {
+			writeTestFile(t, filepath.Join(koshoDir, KOSHO_VERSION_FILE), tt.version)
+		}
+
+		// migrating twice must be the same as migrating once
+		for range 2 {
+			if err := migrateKoshoRepo(repo); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+
+		version, err := readLayoutVersion(repo)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if version != KoshoLayoutVersion() {
+			t.Errorf("%s: version = %d, want %d", tt.name, version, KoshoLayoutVersion())
+		}
+		if tt.rootGitIgnore != "" {
+			if got := readTestFile(t, filepath.Join(repo, ".gitignore")); got != tt.wantRoot {
+				t.Errorf("%s: root .gitignore = %q, want %q", tt.name, got, tt.wantRoot)
+			}
+		}
+		if got := readTestFile(t, filepath.Join(koshoDir, ".gitignore")); got != tt.wantKosho {
+			t.Errorf("%s: .kosho/.gitignore = %q, want %q", tt.name, got, tt.wantKosho)
+		}
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
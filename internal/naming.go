@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"time"
+)
+
+const DEFAULT_BRANCH_TEMPLATE = "{user}/{date}-{words}"
+
+var branchTemplateRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// branchTemplateFields render the placeholders available in branch templates
+var branchTemplateFields = map[string]func(now time.Time) (string, error){
+	"user": func(time.Time) (string, error) {
+		name := os.Getenv("USER")
+		if current, err := user.Current(); err == nil {
+			name = current.Username
+		}
+		if name = sluggify(name); name == "" {
+			name = "kosho"
+		}
+		return name, nil
+	},
+	"date": func(now time.Time) (string, error) {
+		return now.Format("2006-01-02"), nil
+	},
+	"time": func(now time.Time) (string, error) {
+		return now.Format("1504"), nil
+	},
+	"words": func(time.Time) (string, error) {
+		adjective, err := randomChoice(branchAdjectives)
+		if err != nil {
+			return "", err
+		}
+		noun, err := randomChoice(branchNouns)
+		if err != nil {
+			return "", err
+		}
+		return adjective + "-" + noun, nil
+	},
+	"rand": func(time.Time) (string, error) {
+		b := make([]byte, 3)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b), nil
+	},
+}
+
+var branchAdjectives = []string{
+	"amber", "bold", "brave", "brisk", "calm", "clever", "cosmic", "crisp",
+	"eager", "fancy", "gentle", "golden", "happy", "jolly", "keen", "lively",
+	"lucky", "mellow", "nimble", "plucky", "quick", "quiet", "rapid", "shiny",
+	"silent", "snappy", "steady", "sunny", "swift", "tidy", "vivid", "witty",
+}
+
+var branchNouns = []string{
+	"badger", "beacon", "comet", "condor", "falcon", "ferret", "fjord", "gecko",
+	"harbor", "heron", "island", "koala", "lagoon", "lantern", "lynx", "maple",
+	"meadow", "otter", "panda", "pebble", "quokka", "raven", "river", "salmon",
+	"sparrow", "summit", "thistle", "tiger", "walrus", "willow", "yak", "zephyr",
+}
+
+func randomChoice(choices []string) (string, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(choices))))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate random name: %w", err)
+	}
+	return choices[i.Int64()], nil
+}
+
+func validateBranchTemplate(template string) error {
+	for _, match := range branchTemplateRegex.FindAllStringSubmatch(template, -1) {
+		if _, ok := branchTemplateFields[match[1]]; !ok {
+			return fmt.Errorf("unknown placeholder {%s}, expected one of {user}, {date}, {time}, {words} or {rand}", match[1])
+		}
+	}
+	return nil
+}
+
+func renderBranchTemplate(template string, now time.Time) (string, error) {
+	var renderErr error
+	name := branchTemplateRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		field, ok := branchTemplateFields[placeholder[1:len(placeholder)-1]]
+		if !ok {
+			renderErr = fmt.Errorf("unknown placeholder %s", placeholder)
+			return ""
+		}
+		value, err := field(now)
+		if err != nil {
+			renderErr = err
+		}
+		return value
+	})
+	return name, renderErr
+}
+
+// GenerateBranchName renders the configured branch template into a valid
+// branch name which doesn't exist yet, and whose worktree name isn't taken.
+// A numeric suffix is added if the rendered name is taken.
+func (kr *KoshoDir) GenerateBranchName() (string, error) {
+	template := kr.branchTemplate
+	if template == "" {
+		template = DEFAULT_BRANCH_TEMPLATE
+	}
+
+	base, err := renderBranchTemplate(template, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("failed to render branch template %q: %w", template, err)
+	}
+
+	for i := 1; i <= 100; i++ {
+		branch := base
+		if i > 1 {
+			branch = fmt.Sprintf("%s-%d", base, i)
+		}
+
+		cmd := exec.Command("git", "check-ref-format", "--branch", branch)
+		cmd.Dir = kr.repoPath
+		if err := cmd.Run(); err != nil || DefaultWorktreeName(branch) == "" {
+			return "", fmt.Errorf("branch template %q produced an invalid branch name %q", template, branch)
+		}
+		if BranchExists(kr.repoPath, branch) {
+			continue
+		}
+
+		kw, err := kr.ResolveWorktree(branch, "")
+		if err != nil {
+			return "", err
+		}
+		if kw.State == "" && kw.Name() == DefaultWorktreeName(branch) {
+			return branch, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find an unused branch name for template %q", template)
+}
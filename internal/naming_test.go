@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRenderBranchTemplate(t *testing.T) {
+	now := time.Date(2024, time.March, 5, 9, 7, 0, 0, time.UTC)
+	user, err := branchTemplateFields["user"](now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"feat/{date}", `^feat/2024-03-05$`},
+		{"{date}-{time}", `^2024-03-05-0907$`},
+		{"{user}/wip", `^` + regexp.QuoteMeta(user) + `/wip$`},
+		{"{words}", `^[a-z]+-[a-z]+$`},
+		{"tmp-{rand}", `^tmp-[0-9a-f]{6}$`},
+		{"no-placeholders", `^no-placeholders$`},
+		{DEFAULT_BRANCH_TEMPLATE, `^` + regexp.QuoteMeta(user) + `/2024-03-05-[a-z]+-[a-z]+$`},
+	}
+	for _, tt := range tests {
+		got, err := renderBranchTemplate(tt.template, now)
+		if err != nil {
+			t.Errorf("renderBranchTemplate(%q) failed: %v", tt.template, err)
+			continue
+		}
+		if !regexp.MustCompile(tt.want).MatchString(got) {
+			t.Errorf("renderBranchTemplate(%q) = %q, want a match for %s", tt.template, got, tt.want)
+		}
+	}
+
+	if _, err := renderBranchTemplate("{branch}", now); err == nil {
+		t.Errorf("renderBranchTemplate(%q) succeeded", "{branch}")
+	}
+}
+
+func TestValidateBranchTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{DEFAULT_BRANCH_TEMPLATE, true},
+		{"{user}/{date}-{time}-{rand}", true},
+		{"plain", true},
+		{"{}", false},
+		{"{User}", false},
+		{"{user}/{branch}", false},
+	}
+	for _, tt := range tests {
+		err := validateBranchTemplate(tt.template)
+		if tt.valid && err != nil {
+			t.Errorf("validateBranchTemplate(%q): unexpected error: %v", tt.template, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("validateBranchTemplate(%q): expected an error", tt.template)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ListPool returns the worktrees waiting in the warm pool, oldest first
+func (kr *KoshoDir) ListPool() ([]KoshoWorktree, error) {
+	entries, err := os.ReadDir(kr.PoolDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read pool directory: %w", err)
+	}
+
+	var ids []int
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		id, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	pool := make([]KoshoWorktree, 0, len(ids))
+	for _, id := range ids {
+		pool = append(pool, KoshoWorktree{KoshoDir: *kr, WorktreeName: strconv.Itoa(id), pooled: true})
+	}
+	return pool, nil
+}
+
+// CreatePooledWorktree adds a new worktree to the warm pool, checked out at a
+// detached HEAD at ref and set up like a new worktree. The caller is
+// responsible for running the create hook.
+func (kr *KoshoDir) CreatePooledWorktree(ref string) (*KoshoWorktree, error) {
+	pool, err := kr.ListPool()
+	if err != nil {
+		return nil, err
+	}
+	nextId := 1
+	if len(pool) > 0 {
+		lastId, _ := strconv.Atoi(pool[len(pool)-1].WorktreeName)
+		nextId = lastId + 1
+	}
+	kw := &KoshoWorktree{KoshoDir: *kr, WorktreeName: strconv.Itoa(nextId), pooled: true}
+
+	if err := os.MkdirAll(filepath.Dir(kw.WorktreePath()), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create pool directory: %w", err)
+	}
+
+	cmd := worktreeCmd("add", "--detach", kw.WorktreePath(), ref)
+	cmd.Dir = kr.RepoPath()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pooled worktree: %w\nOutput: %s", err, string(output))
+	}
+	err = relativizeGitLink(kw.WorktreePath())
+	if err == nil {
+		_, err = kw.setupWorktree(CreateOptions{})
+	}
+	if err != nil {
+		if removeErr := kw.Remove(true); removeErr != nil {
+			return nil, fmt.Errorf("%w (failed to remove pooled worktree: %w)", err, removeErr)
+		}
+		return nil, err
+	}
+
+	return kw, nil
+}
+
+// KOSHO_POOL_LOCK_SUFFIX is appended to a pooled worktree's path to name the
+// lock file of the process claiming or removing it
+const KOSHO_POOL_LOCK_SUFFIX = ".lock"
+
+// LockPooledWorktree gives this process exclusive use of a pooled worktree,
+// returning false if another process holds its lock or it was claimed since
+// the pool was listed. Locks left by processes which have exited are taken
+// over. Call unlock once the worktree has left the pool.
+func (kw *KoshoWorktree) LockPooledWorktree() (unlock func(), locked bool, err error) {
+	lockPath := kw.WorktreePath() + KOSHO_POOL_LOCK_SUFFIX
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			content, _ := os.ReadFile(lockPath)
+			pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+			if pid != 0 && processExists(pid) {
+				return nil, false, nil
+			}
+			// left behind by a process which exited mid claim
+			if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+				return nil, false, fmt.Errorf("failed to remove stale lock %s: %w", lockPath, err)
+			}
+			continue
+		} else if err != nil {
+			return nil, false, fmt.Errorf("failed to create %s: %w", lockPath, err)
+		}
+
+		_, err = fmt.Fprintf(file, "%d\n", os.Getpid())
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		unlock = func() { os.Remove(lockPath) }
+		if err != nil {
+			unlock()
+			return nil, false, fmt.Errorf("failed to write %s: %w", lockPath, err)
+		}
+
+		// another process may have claimed it between listing and locking
+		if exists, err := kw.Exists(); err != nil || !exists {
+			unlock()
+			return nil, false, err
+		}
+		return unlock, true, nil
+	}
+	return nil, false, nil
+}
+
+// ClaimPooledWorktree takes a worktree from the warm pool, checks out the
+// branch in it and moves it into place. New branches start at the pooled
+// worktree's HEAD, the commit the pool was filled from, which is what its
+// create hook was run against. Returns false if the pool is empty, every
+// pooled worktree is being claimed by another process, or the claim failed.
+// Once the branch has been checked out, a failed claim discards the pooled
+// worktree.
+func (kw *KoshoWorktree) ClaimPooledWorktree() (bool, error) {
+	pool, err := kw.KoshoDir.ListPool()
+	if err != nil {
+		return false, err
+	}
+
+	var pooled *KoshoWorktree
+	for i := range pool {
+		unlock, locked, err := pool[i].LockPooledWorktree()
+		if err != nil {
+			return false, err
+		}
+		if locked {
+			defer unlock()
+			pooled = &pool[i]
+			break
+		}
+	}
+	if pooled == nil {
+		return false, nil
+	}
+
+	args := []string{"switch", kw.BranchName}
+	if !BranchExists(kw.KoshoDir.repoPath, kw.BranchName) {
+		args = []string{"switch", "-c", kw.BranchName}
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = pooled.WorktreePath()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return false, fmt.Errorf("failed to check out branch in pooled worktree: %w\nOutput: %s", err, string(output))
+	}
+
+	metadata, err := pooled.LoadMetadata()
+	if err == nil {
+		err = kw.moveFromPool(pooled)
+	}
+	if err != nil {
+		if removeErr := pooled.Remove(true); removeErr != nil {
+			return false, fmt.Errorf("%w (failed to remove pooled worktree: %w)", err, removeErr)
+		}
+		return false, err
+	}
+
+	metadata.Branch = kw.BranchName
+	if err := kw.finishClaim(metadata); err != nil {
+		if removeErr := kw.Remove(true); removeErr != nil {
+			return false, fmt.Errorf("%w (failed to remove claimed worktree: %w)", err, removeErr)
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (kw *KoshoWorktree) finishClaim(metadata *WorktreeMetadata) error {
+	if err := kw.SaveMetadata(metadata); err != nil {
+		return err
+	}
+
+	if kw.KoshoDir.config.UseSubmodules() {
+		if err := kw.initSubmodules(); err != nil {
+			return err
+		}
+	}
+
+	// templates may reference the worktree name and branch, which weren't
+	// known when the worktree was pooled
+	for _, rule := range kw.KoshoDir.config.Provision {
+		if rule.mode() != PROVISION_TEMPLATE {
+			continue
+		}
+		srcRoot, err := kw.KoshoDir.ProvisionSource()
+		if err != nil {
+			return err
+		}
+		if _, err := kw.provisionRule(srcRoot, rule); err != nil {
+			return fmt.Errorf("failed to provision %s: %w", rule.Path, err)
+		}
+	}
+	return nil
+}
+
+func (kw *KoshoWorktree) moveFromPool(pooled *KoshoWorktree) error {
+	if err := moveWorktree(kw.KoshoDir.RepoPath(), pooled.WorktreePath(), kw.WorktreePath()); err != nil {
+		return fmt.Errorf("failed to move pooled worktree: %w", err)
+	}
+	return pooled.RemoveMetadata()
+}
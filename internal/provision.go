@@ -0,0 +1,382 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type ProvisionMode string
+
+const (
+	// Copy the file or directory into the worktree
+	PROVISION_COPY ProvisionMode = "copy"
+
+	// Symlink the worktree path to the file or directory in the main checkout
+	PROVISION_SYMLINK ProvisionMode = "symlink"
+
+	// Render the file as a Go template into the worktree
+	PROVISION_TEMPLATE ProvisionMode = "template"
+
+	// Clone a directory using copy-on-write reflinks where supported
+	PROVISION_CLONE ProvisionMode = "clone"
+)
+
+// ProvisionRule describes an untracked file or directory which is provisioned
+// from the main checkout into each new worktree
+type ProvisionRule struct {
+	// Path relative to the repository root
+	Path string `json:"path"`
+
+	// Destination relative to the worktree root, defaults to Path
+	Dest string `json:"dest,omitempty"`
+
+	// One of copy, symlink, template or clone. Defaults to copy.
+	Mode ProvisionMode `json:"mode,omitempty"`
+
+	// Clone from this worktree instead of the main checkout. Only used by the
+	// clone mode.
+	From string `json:"from,omitempty"`
+
+	// Method used by the clone mode when reflinks are not supported, either
+	// copy or hardlink. Defaults to copy.
+	Fallback CloneMethod `json:"fallback,omitempty"`
+}
+
+// ProvisionResult describes how a rule was applied to a worktree
+type ProvisionResult struct {
+	Rule ProvisionRule
+
+	// Only set by the clone mode
+	Clone *CloneStats
+}
+
+// ProvisionTemplateData is passed to files provisioned with the template mode
+type ProvisionTemplateData struct {
+	Worktree     string
+	Branch       string
+	Repo         string
+	WorktreePath string
+}
+
+func (r *ProvisionRule) mode() ProvisionMode {
+	if r.Mode == "" {
+		return PROVISION_COPY
+	}
+	return r.Mode
+}
+
+func (r *ProvisionRule) dest() string {
+	if r.Dest == "" {
+		return r.Path
+	}
+	return r.Dest
+}
+
+func (r *ProvisionRule) validate() error {
+	switch r.mode() {
+	case PROVISION_COPY, PROVISION_SYMLINK, PROVISION_TEMPLATE, PROVISION_CLONE:
+	default:
+		return fmt.Errorf("unknown mode %q", r.Mode)
+	}
+	if r.mode() != PROVISION_CLONE && (r.From != "" || r.Fallback != "") {
+		return fmt.Errorf("from and fallback are only supported by the clone mode")
+	}
+	switch r.Fallback {
+	case "", CLONE_COPY, CLONE_HARDLINK:
+	default:
+		return fmt.Errorf("unknown fallback %q", r.Fallback)
+	}
+	if r.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	for _, p := range []string{r.Path, r.dest()} {
+		if !filepath.IsLocal(p) {
+			return fmt.Errorf("path must be relative and may not leave the repository: %s", p)
+		}
+	}
+	return nil
+}
+
+// Provision applies the provision rules from the kosho config to the worktree,
+// replacing any previously provisioned files. Nothing is provisioned into the
+// checkout the files are provisioned from.
+func (kw *KoshoWorktree) Provision() ([]ProvisionResult, error) {
+	if len(kw.KoshoDir.config.Provision) == 0 {
+		return nil, nil
+	}
+	srcRoot, err := kw.KoshoDir.ProvisionSource()
+	if err != nil {
+		return nil, err
+	}
+	if resolvePath(srcRoot) == resolvePath(kw.WorktreePath()) {
+		return nil, nil
+	}
+
+	results := make([]ProvisionResult, 0, len(kw.KoshoDir.config.Provision))
+	for _, rule := range kw.KoshoDir.config.Provision {
+		result, err := kw.provisionRule(srcRoot, rule)
+		if err != nil {
+			return results, fmt.Errorf("failed to provision %s: %w", rule.Path, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (kw *KoshoWorktree) provisionRule(srcRoot string, rule ProvisionRule) (ProvisionResult, error) {
+	result := ProvisionResult{Rule: rule}
+
+	if rule.From != "" {
+		donor, err := kw.KoshoDir.FindNamedWorktree(rule.From)
+		if err != nil {
+			return result, err
+		}
+		if donor.WorktreeName == kw.WorktreeName {
+			return result, fmt.Errorf("worktree '%s' can't clone from itself", kw.WorktreeName)
+		}
+		srcRoot = donor.WorktreePath()
+	}
+	src := filepath.Join(srcRoot, rule.Path)
+	dest := filepath.Join(kw.WorktreePath(), rule.dest())
+
+	srcInfo, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return result, fmt.Errorf("source %s does not exist", src)
+	} else if err != nil {
+		return result, fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+
+	// only untracked, ignored files are provisioned, so that provisioning
+	// never overwrites checked out files
+	if ignored, err := isIgnored(srcRoot, rule.Path, srcInfo.IsDir()); err != nil {
+		return result, err
+	} else if !ignored {
+		return result, fmt.Errorf("%s is not ignored by git in %s, only ignored files can be provisioned", rule.Path, srcRoot)
+	}
+	if ignored, err := isIgnored(kw.WorktreePath(), rule.dest(), srcInfo.IsDir()); err != nil {
+		return result, err
+	} else if !ignored {
+		return result, fmt.Errorf("%s is not ignored by git in worktree '%s', only ignored files can be provisioned", rule.dest(), kw.Name())
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return result, fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	// replace previously provisioned symlinks regardless of mode, and
+	// previously provisioned files and directories unless symlinking, so
+	// that files removed from the source don't linger
+	if destInfo, err := os.Lstat(dest); err == nil && (rule.mode() != PROVISION_SYMLINK || destInfo.Mode()&os.ModeSymlink != 0) {
+		if err := os.RemoveAll(dest); err != nil {
+			return result, fmt.Errorf("failed to remove previously provisioned %s: %w", dest, err)
+		}
+	}
+
+	switch rule.mode() {
+	case PROVISION_SYMLINK:
+		if _, err := os.Lstat(dest); err == nil {
+			return result, fmt.Errorf("refusing to replace %s with a symlink", dest)
+		}
+		err = os.Symlink(src, dest)
+
+	case PROVISION_TEMPLATE:
+		if srcInfo.IsDir() {
+			return result, fmt.Errorf("template source %s is a directory", src)
+		}
+		err = kw.renderTemplate(src, dest, srcInfo.Mode().Perm())
+
+	case PROVISION_CLONE:
+		fallback := rule.Fallback
+		if fallback == "" {
+			fallback = CLONE_COPY
+		}
+		var stats CloneStats
+		stats, err = cloneTree(src, dest, fallback)
+		result.Clone = &stats
+
+	default:
+		err = copyPath(src, dest)
+	}
+
+	return result, err
+}
+
+// isIgnored reports whether path, relative to the checkout at root, is ignored
+// by git. Tracked files are never ignored.
+func isIgnored(root, path string, dir bool) (bool, error) {
+	if dir {
+		// patterns like /node_modules/ only match directories, which git
+		// can only tell if the path exists or ends with a slash
+		path += "/"
+	}
+	cmd := exec.Command("git", "check-ignore", "--quiet", "--", path)
+	cmd.Dir = root
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check whether %s is ignored: %w", path, err)
+	}
+	return true, nil
+}
+
+// ProvisionSource returns the checkout provisioned files are read from: the
+// main checkout, or in a bare repository, the worktree of its default branch
+func (kr *KoshoDir) ProvisionSource() (string, error) {
+	gitWorktrees, err := ListGitWorktrees(kr.repoPath)
+	if err != nil {
+		return "", err
+	}
+	if len(gitWorktrees) == 0 || !gitWorktrees[0].Bare {
+		return kr.repoPath, nil
+	}
+
+	branch, err := DefaultBranch(kr.repoPath)
+	if err != nil {
+		return "", err
+	}
+	for _, gitWorktree := range gitWorktrees[1:] {
+		if gitWorktree.Branch == branch && !gitWorktree.Prunable {
+			return gitWorktree.Path, nil
+		}
+	}
+	return "", fmt.Errorf("bare repository has no worktree for its default branch '%s' to provision from", branch)
+}
+
+func (kw *KoshoWorktree) renderTemplate(src, dest string, perm os.FileMode) error {
+	tmpl, err := template.New(filepath.Base(src)).Option("missingkey=error").ParseFiles(src)
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	branch, err := kw.GitBranch()
+	if err != nil {
+		branch = kw.BranchName
+	}
+
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, ProvisionTemplateData{
+		Worktree:     kw.WorktreeName,
+		Branch:       branch,
+		Repo:         kw.KoshoDir.RepoPath(),
+		WorktreePath: kw.WorktreePath(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+
+	return os.WriteFile(dest, out.Bytes(), perm)
+}
+
+// copyPath recursively copies a file or directory, preserving permissions and
+// symlinks. Existing files at the destination are overwritten.
+func copyPath(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+
+		// skip sockets, devices and other special files
+		return nil
+	})
+}
+
+func copyFile(src, dest string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// dest may be a hardlink to src, such as a file cloned with the hardlink
+	// fallback, so replace it rather than truncating the shared inode
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// String returns a short human readable description of the result
+func (r *ProvisionResult) String() string {
+	if r.Clone == nil {
+		return "Provisioned " + r.Rule.String()
+	}
+
+	source := "main checkout"
+	if r.Rule.From != "" {
+		source = fmt.Sprintf("worktree '%s'", r.Rule.From)
+	}
+
+	// trees can mix methods, when reflinks fail part way through
+	var methods []string
+	for _, method := range CloneMethods {
+		if n := r.Clone.Methods[method]; n > 0 {
+			methods = append(methods, fmt.Sprintf("%d via %s", n, method))
+		}
+	}
+	summary := fmt.Sprintf("Cloned %s from %s: %d files", r.Rule.Path, source, r.Clone.Files)
+	if len(methods) > 0 {
+		summary += fmt.Sprintf(" (%s)", strings.Join(methods, ", "))
+	}
+	summary += fmt.Sprintf(", %s in %s", formatBytes(r.Clone.Bytes),
+		r.Clone.Duration.Round(time.Millisecond))
+	if r.Clone.SharedBytes > 0 {
+		summary += fmt.Sprintf(", shared %s instead of copying it", formatBytes(r.Clone.SharedBytes))
+		if r.Clone.Saved > 0 {
+			summary += fmt.Sprintf(", saving about %s", r.Clone.Saved.Round(time.Millisecond))
+		}
+	}
+	return summary
+}
+
+// String returns a short human readable description of the rule
+func (r *ProvisionRule) String() string {
+	if r.dest() != r.Path {
+		return fmt.Sprintf("%s -> %s (%s)", r.Path, r.dest(), r.mode())
+	}
+	return fmt.Sprintf("%s (%s)", r.Path, r.mode())
+}
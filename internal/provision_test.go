@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProvisionRuleValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  ProvisionRule
+		valid bool
+	}{
+		{"copy", ProvisionRule{Path: ".env"}, true},
+		{"symlink with dest", ProvisionRule{Path: "data", Dest: "shared/data", Mode: PROVISION_SYMLINK}, true},
+		{"clone from worktree", ProvisionRule{Path: "node_modules", Mode: PROVISION_CLONE, From: "main", Fallback: CLONE_HARDLINK}, true},
+		{"missing path", ProvisionRule{}, false},
+		{"unknown mode", ProvisionRule{Path: ".env", Mode: "move"}, false},
+		{"from without clone", ProvisionRule{Path: ".env", From: "main"}, false},
+		{"unknown fallback", ProvisionRule{Path: "target", Mode: PROVISION_CLONE, Fallback: "rsync"}, false},
+		{"absolute path", ProvisionRule{Path: "/etc/passwd"}, false},
+		{"escaping dest", ProvisionRule{Path: ".env", Dest: "../.env"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.rule.validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestProvision(t *testing.T) {
+	repo := newTestRepo(t)
+	writeTestFile(t, filepath.Join(repo, ".gitignore"), "/data/\n.env\n")
+	writeTestFile(t, filepath.Join(repo, "README"), "tracked\n")
+	runGit(t, repo, "add", ".gitignore", "README")
+	runGit(t, repo, "commit", "--quiet", "-m", "ignore data")
+	writeTestFile(t, filepath.Join(repo, ".env"), "A=1\n")
+	writeTestFile(t, filepath.Join(repo, "data", "a"), "a\n")
+	writeTestFile(t, filepath.Join(repo, "data", "b"), "b\n")
+
+	kr := loadTestKoshoDir(t)
+	kw, err := kr.ResolveWorktree("feat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kw.CreateWorktree(CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	kw.KoshoDir.config.Provision = []ProvisionRule{{Path: ".env"}, {Path: "data"}}
+	if _, err := kw.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{".env", "data/a", "data/b"} {
+		if _, err := os.Stat(filepath.Join(kw.WorktreePath(), path)); err != nil {
+			t.Errorf("%s wasn't provisioned: %v", path, err)
+		}
+	}
+
+	// re-provisioning replaces the directory rather than merging into it
+	if err := os.Remove(filepath.Join(repo, "data", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kw.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(kw.WorktreePath(), "data", "b")); !os.IsNotExist(err) {
+		t.Errorf("data/b was left behind after it was removed from the source")
+	}
+
+	// tracked files are never provisioned, in either direction
+	for _, rule := range []ProvisionRule{{Path: "README"}, {Path: ".env", Dest: "README"}} {
+		kw.KoshoDir.config.Provision = []ProvisionRule{rule}
+		if _, err := kw.Provision(); err == nil || !strings.Contains(err.Error(), "not ignored") {
+			t.Errorf("provisioning %s to %s: expected a not ignored error, got %v", rule.Path, rule.dest(), err)
+		}
+	}
+	content, err := os.ReadFile(filepath.Join(kw.WorktreePath(), "README"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "tracked\n" {
+		t.Errorf("tracked README was overwritten: %q", content)
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
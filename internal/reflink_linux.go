@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// FICLONE from linux/fs.h
+const ficlone = 0x40049409
+
+// reflinkFile clones src into dest using the FICLONE ioctl, which shares the
+// underlying extents on filesystems which support it, such as btrfs and XFS
+func reflinkFile(src, dest string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// dest may be a hardlink to src from an earlier clone, so replace it
+	// rather than truncating the shared inode
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	if errno != 0 {
+		out.Close()
+		os.Remove(dest)
+		return errno
+	}
+	return out.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// reflinkFile is only supported on linux
+func reflinkFile(src, dest string, perm os.FileMode) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Rename renames the worktree's branch to newBranch with `git branch -m` and
+// moves the worktree to newName, keeping its untracked files and metadata.
+// Worktrees stored outside the worktrees directory are renamed in place.
+// Refuses if the branch or worktree already exists, or if a kosho command is
+// running in the worktree.
+func (kw *KoshoWorktree) Rename(newBranch, newName string) (*KoshoWorktree, error) {
+	if kw.DetachedRef != "" {
+		return nil, fmt.Errorf("worktree '%s' is detached at %s and has no branch to rename", kw.Name(), kw.DetachedRef)
+	}
+
+	switch kw.State {
+	case WORKTREE_ORPHAN, WORKTREE_PRUNABLE:
+		return nil, fmt.Errorf("worktree '%s' is %s, run `kosho doctor` first", kw.Name(), kw.State)
+	}
+
+	pids, err := kw.RunningPids()
+	if err != nil {
+		return nil, err
+	}
+	if len(pids) > 0 {
+		return nil, fmt.Errorf("a command is running in worktree '%s' (pid %d)", kw.Name(), pids[0])
+	}
+
+	repoPath := kw.KoshoDir.RepoPath()
+	if BranchExists(repoPath, newBranch) {
+		return nil, fmt.Errorf("branch %s already exists", newBranch)
+	}
+
+	renamed := &KoshoWorktree{KoshoDir: kw.KoshoDir, BranchName: newBranch, WorktreeName: newName, State: kw.State}
+	if kw.path != "" {
+		renamed.path = filepath.Join(filepath.Dir(kw.path), newName)
+	}
+	moved := renamed.WorktreePath() != kw.WorktreePath()
+	if moved {
+		if exists, err := renamed.Exists(); err != nil {
+			return nil, err
+		} else if exists {
+			return nil, fmt.Errorf("%s already exists", renamed.WorktreePath())
+		}
+		if _, err := os.Stat(renamed.metadataPath()); err == nil {
+			return nil, fmt.Errorf("kosho already manages a worktree named '%s'", newName)
+		}
+	}
+
+	metadata, err := kw.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := renameBranch(kw.WorktreePath(), kw.BranchName, newBranch); err != nil {
+		return nil, err
+	}
+
+	if moved {
+		if err := moveWorktree(repoPath, kw.WorktreePath(), renamed.WorktreePath()); err != nil {
+			if undoErr := renameBranch(kw.WorktreePath(), newBranch, kw.BranchName); undoErr != nil {
+				return nil, fmt.Errorf("%w (failed to restore branch name: %w)", err, undoErr)
+			}
+			return nil, err
+		}
+	}
+
+	metadata.Branch = newBranch
+	if metadata.AdoptedInPlace {
+		metadata.AdoptedFrom = renamed.WorktreePath()
+	}
+	if err := renamed.SaveMetadata(metadata); err != nil {
+		return nil, err
+	}
+	if moved {
+		if err := kw.clearRunning(); err != nil {
+			return nil, err
+		}
+		if err := kw.RemoveMetadata(); err != nil {
+			return nil, err
+		}
+	}
+	return renamed, nil
+}
+
+func renameBranch(worktreePath, oldBranch, newBranch string) error {
+	cmd := exec.Command("git", "branch", "-m", oldBranch, newBranch)
+	cmd.Dir = worktreePath
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to rename branch %s: %w\nOutput: %s", oldBranch, err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+const KOSHO_RUNNING_SUFFIX = ".running"
+
+// runningDir contains an empty file named after the pid of each kosho
+// process running a command in the worktree
+func (kw *KoshoWorktree) runningDir() string {
+	return filepath.Join(kw.KoshoDir.repoPath, KOSHO_DIR, KOSHO_METADATA_DIR, kw.WorktreeName+KOSHO_RUNNING_SUFFIX)
+}
+
+// markRunning records that this process is running a command in the
+// worktree, returning a function which removes the record
+func (kw *KoshoWorktree) markRunning() (func(), error) {
+	dir := kw.runningDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	pidFile := filepath.Join(dir, strconv.Itoa(os.Getpid()))
+	if err := os.WriteFile(pidFile, nil, 0644); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", pidFile, err)
+	}
+	return func() {
+		os.Remove(pidFile)
+		// only succeeds once no other process is running in the worktree
+		os.Remove(dir)
+	}, nil
+}
+
+// RunningPids returns the pids of the kosho processes running a command in
+// the worktree, ignoring processes which exited without clearing their record
+func (kw *KoshoWorktree) RunningPids() ([]int, error) {
+	entries, err := os.ReadDir(kw.runningDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", kw.runningDir(), err)
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if processExists(pid) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// clearRunning removes the records left behind by processes which exited
+// without clearing them
+func (kw *KoshoWorktree) clearRunning() error {
+	if err := os.RemoveAll(kw.runningDir()); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", kw.runningDir(), err)
+	}
+	return nil
+}
+
+func processExists(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	// EPERM means the process exists but belongs to another user
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ParseSparsePaths parses a comma separated list of directories relative to
+// the repository root
+func ParseSparsePaths(list string) ([]string, error) {
+	var paths []string
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		path = filepath.ToSlash(filepath.Clean(path))
+		if !filepath.IsLocal(path) || path == "." {
+			return nil, fmt.Errorf("sparse paths must be directories relative to the repository root: %s", path)
+		}
+		if !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// SparsePaths returns the directories recorded for a sparse worktree, or nil
+// if the worktree has a full checkout
+func (kw *KoshoWorktree) SparsePaths() ([]string, error) {
+	metadata, err := kw.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Sparse, nil
+}
+
+// SparseAdd adds directories to the worktree's sparse checkout, converting a
+// full checkout into a sparse one
+func (kw *KoshoWorktree) SparseAdd(paths []string) error {
+	metadata, err := kw.LoadMetadata()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if !slices.Contains(metadata.Sparse, path) {
+			metadata.Sparse = append(metadata.Sparse, path)
+		}
+	}
+	if err := kw.setSparseCheckout(metadata.Sparse); err != nil {
+		return err
+	}
+	return kw.SaveMetadata(metadata)
+}
+
+// SparseRemove removes directories from the worktree's sparse checkout.
+// Removing every directory restores a full checkout.
+func (kw *KoshoWorktree) SparseRemove(paths []string) error {
+	metadata, err := kw.LoadMetadata()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if !slices.Contains(metadata.Sparse, path) {
+			return fmt.Errorf("%s is not part of the sparse checkout", path)
+		}
+	}
+	metadata.Sparse = slices.DeleteFunc(metadata.Sparse, func(path string) bool {
+		return slices.Contains(paths, path)
+	})
+
+	if len(metadata.Sparse) == 0 {
+		err = kw.disableSparseCheckout()
+	} else {
+		err = kw.setSparseCheckout(metadata.Sparse)
+	}
+	if err != nil {
+		return err
+	}
+	return kw.SaveMetadata(metadata)
+}
+
+// setSparseCheckout restricts the worktree to the given directories using
+// cone mode sparse checkout
+func (kw *KoshoWorktree) setSparseCheckout(paths []string) error {
+	args := append([]string{"sparse-checkout", "set", "--cone"}, paths...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = kw.WorktreePath()
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to set sparse checkout: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
+
+func (kw *KoshoWorktree) disableSparseCheckout() error {
+	cmd := exec.Command("git", "sparse-checkout", "disable")
+	cmd.Dir = kw.WorktreePath()
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to disable sparse checkout: %w\nOutput: %s", err, string(output))
+	}
+	return nil
+}
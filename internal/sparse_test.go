@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseSparsePaths(t *testing.T) {
+	tests := []struct {
+		list  string
+		want  []string
+		valid bool
+	}{
+		{"", nil, true},
+		{"services/api", []string{"services/api"}, true},
+		{"services/api,libs/common", []string{"services/api", "libs/common"}, true},
+		{" services/api , libs/common ,", []string{"services/api", "libs/common"}, true},
+		{"services/api/,./libs//common", []string{"services/api", "libs/common"}, true},
+		{"libs,libs/", []string{"libs"}, true},
+		{"services/../libs", []string{"libs"}, true},
+		{"/etc", nil, false},
+		{"../other", nil, false},
+		{"services/../..", nil, false},
+		{".", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseSparsePaths(tt.list)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("ParseSparsePaths(%q) = %q, expected an error", tt.list, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSparsePaths(%q) failed: %v", tt.list, err)
+		} else if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseSparsePaths(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Submodule is a submodule declared in .gitmodules
+type Submodule struct {
+	Name string
+	Path string
+}
+
+// listSubmodules returns the submodules declared in the .gitmodules file at
+// the root of dir
+func listSubmodules(dir string) ([]Submodule, error) {
+	gitModulesPath := filepath.Join(dir, ".gitmodules")
+	if _, err := os.Stat(gitModulesPath); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", gitModulesPath, err)
+	}
+
+	cmd := exec.Command("git", "config", "--file", gitModulesPath, "--get-regexp", `^submodule\..*\.path$`)
+	output, err := cmd.Output()
+	if err != nil {
+		// git config exits with 1 when nothing matches
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", gitModulesPath, err)
+	}
+
+	var submodules []Submodule
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		key, path, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "submodule."), ".path")
+		submodules = append(submodules, Submodule{Name: name, Path: path})
+	}
+	return submodules, nil
+}
+
+// initSubmodules initializes and checks out the worktree's submodules. Where
+// the main checkout has already cloned a submodule, its object store is used
+// as a reference to avoid fetching objects again.
+func (kw *KoshoWorktree) initSubmodules() error {
+	submodules, err := listSubmodules(kw.WorktreePath())
+	if err != nil || len(submodules) == 0 {
+		return err
+	}
+
+	commonDir, err := GitCommonDir(kw.KoshoDir.RepoPath())
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		args := []string{"-c", "submodule.alternateErrorStrategy=info", "submodule", "update", "--init", "--recursive"}
+		moduleDir := filepath.Join(commonDir, "modules", submodule.Name)
+		if _, err := os.Stat(moduleDir); err == nil {
+			args = append(args, "--reference", moduleDir)
+		}
+		args = append(args, "--", submodule.Path)
+
+		cmd := exec.Command("git", args...)
+		cmd.Dir = kw.WorktreePath()
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to initialize submodule %s: %w\nOutput: %s", submodule.Path, err, string(output))
+		}
+	}
+	return nil
+}
+
+// SubmoduleDrift counts submodules which are checked out at a different
+// commit than the one recorded in the worktree, and submodules which haven't
+// been initialized
+func (kw *KoshoWorktree) SubmoduleDrift() (drifted int, uninitialized int, err error) {
+	statuses, err := kw.submoduleStatuses()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, status := range statuses {
+		switch status {
+		case '+', 'U':
+			drifted++
+		case '-':
+			uninitialized++
+		}
+	}
+	return drifted, uninitialized, nil
+}
+
+// hasInitializedSubmodules returns true if any of the worktree's submodules
+// have been checked out
+func (kw *KoshoWorktree) hasInitializedSubmodules() (bool, error) {
+	statuses, err := kw.submoduleStatuses()
+	if err != nil {
+		return false, err
+	}
+	for _, status := range statuses {
+		if status != '-' {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// submoduleStatuses returns the status prefix character reported by
+// `git submodule status` for each submodule
+func (kw *KoshoWorktree) submoduleStatuses() ([]byte, error) {
+	if _, err := os.Stat(filepath.Join(kw.WorktreePath(), ".gitmodules")); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	cmd := exec.Command("git", "submodule", "status", "--recursive")
+	cmd.Dir = kw.WorktreePath()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get submodule status: %w", err)
+	}
+
+	var statuses []byte
+	for _, line := range strings.Split(string(output), "\n") {
+		if line != "" {
+			statuses = append(statuses, line[0])
+		}
+	}
+	return statuses, nil
+}
+
+// submoduleLink is an initialized submodule whose .git file points to a git
+// directory inside the repository's git directory
+type submoduleLink struct {
+	// path of the submodule relative to the worktree
+	path   string
+	gitDir string
+}
+
+// submoduleLinks returns the initialized submodules of the worktree at
+// worktreePath, including nested submodules, along with the absolute git
+// directories they are linked to
+func submoduleLinks(worktreePath string) ([]submoduleLink, error) {
+	if _, err := os.Stat(filepath.Join(worktreePath, ".gitmodules")); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	cmd := exec.Command("git", "submodule", "foreach", "--quiet", "--recursive", `printf '%s\0' "$displaypath"`)
+	cmd.Dir = worktreePath
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list submodules: %w", err)
+	}
+
+	var links []submoduleLink
+	for _, path := range strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00") {
+		if path == "" {
+			continue
+		}
+		gitDir, ok, err := resolveGitLink(filepath.Join(worktreePath, path))
+		if err != nil {
+			return nil, err
+		}
+		// submodules with their own .git directory move with the worktree
+		if ok {
+			links = append(links, submoduleLink{path: path, gitDir: resolvePath(gitDir)})
+		}
+	}
+	return links, nil
+}
+
+// connect links the submodule checked out in the worktree at worktreePath
+// and its git directory to each other with relative paths, as git does when
+// it moves a submodule
+func (link submoduleLink) connect(worktreePath string) error {
+	path := resolvePath(filepath.Join(worktreePath, link.path))
+	if err := writeGitLink(path, link.gitDir); err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(link.gitDir, path)
+	if err != nil {
+		return fmt.Errorf("failed to make %s relative: %w", path, err)
+	}
+	cmd := exec.Command("git", "config", "--file", filepath.Join(link.gitDir, "config"), "core.worktree", rel)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update submodule %s: %w\nOutput: %s", link.path, err, string(output))
+	}
+	return nil
+}
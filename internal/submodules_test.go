@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMoveWorktreeWithSubmodules(t *testing.T) {
+	repo := newTestRepo(t)
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+
+	lib := filepath.Join(t.TempDir(), "lib")
+	runGit(t, filepath.Dir(lib), "init", "--quiet", "--initial-branch=main", lib)
+	runGit(t, lib, "commit", "--quiet", "--allow-empty", "-m", "lib")
+	runGit(t, repo, "submodule", "--quiet", "add", lib, "lib")
+	runGit(t, repo, "commit", "--quiet", "-m", "add lib")
+
+	kr := loadTestKoshoDir(t)
+	kw, err := kr.ResolveWorktree("feat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kw.CreateWorktree(CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, kw.WorktreePath(), "submodule", "--quiet", "update", "--init")
+
+	renamed, err := kw.Rename("feat-2", "feat-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSubmoduleWorktree(t, repo, renamed.WorktreePath())
+
+	// released worktrees may end up at a different depth
+	dest := filepath.Join(t.TempDir(), "a", "b", "feat-2")
+	path, err := renamed.Release(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSubmoduleWorktree(t, repo, path)
+}
+
+func checkSubmoduleWorktree(t *testing.T, repo, path string) {
+	t.Helper()
+	if status := runGit(t, path, "status", "--porcelain"); status != "" {
+		t.Errorf("worktree at %s isn't clean after moving:\n%s", path, status)
+	}
+	if top := strings.TrimSpace(runGit(t, filepath.Join(path, "lib"), "rev-parse", "--show-toplevel")); top != resolvePath(filepath.Join(path, "lib")) {
+		t.Errorf("submodule toplevel = %s, want %s", top, filepath.Join(path, "lib"))
+	}
+	list := runGit(t, repo, "worktree", "list", "--porcelain")
+	if !strings.Contains(list, "worktree "+resolvePath(path)+"\n") || strings.Contains(list, "prunable") {
+		t.Errorf("git doesn't list the worktree at %s:\n%s", path, list)
+	}
+}
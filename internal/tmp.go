@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+const KOSHO_TMP_PREFIX = "tmp-"
+
+// NewTmpWorktree returns a new uniquely named worktree with a detached HEAD at
+// ref, to be created by `kosho tmp` and removed once its command exits
+func (kr *KoshoDir) NewTmpWorktree(ref string) (*KoshoWorktree, error) {
+	if _, err := ResolveCommit(kr.repoPath, ref); err != nil {
+		return nil, err
+	}
+
+	for range 10 {
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, fmt.Errorf("failed to generate worktree name: %w", err)
+		}
+		kw := &KoshoWorktree{KoshoDir: *kr, DetachedRef: ref, WorktreeName: KOSHO_TMP_PREFIX + hex.EncodeToString(suffix)}
+
+		if exists, err := kw.Exists(); err != nil {
+			return nil, err
+		} else if exists {
+			continue
+		}
+		if _, err := os.Stat(kw.metadataPath()); err == nil {
+			continue
+		}
+		return kw, nil
+	}
+	return nil, fmt.Errorf("failed to find an unused temporary worktree name")
+}
+
+// KeepTmpWorktree clears the worktree's `kosho tmp` owner, so that it's kept
+// rather than collected once the owner exits
+func (kw *KoshoWorktree) KeepTmpWorktree() error {
+	metadata, err := kw.LoadMetadata()
+	if err != nil {
+		return err
+	}
+	metadata.TmpPid = 0
+	return kw.SaveMetadata(metadata)
+}
+
+// TmpOwner returns the pid of the running `kosho tmp` process which owns the
+// worktree, or 0 if it isn't a temporary worktree or its owner has exited
+func (kw *KoshoWorktree) TmpOwner() int {
+	metadata := kw.KoshoDir.loadMetadata(kw.Name())
+	if metadata == nil || metadata.TmpPid == 0 || !processExists(metadata.TmpPid) {
+		return 0
+	}
+	return metadata.TmpPid
+}
+
+// CollectTmpWorktrees removes the temporary worktrees left behind by
+// `kosho tmp` processes which exited without removing them, returning their
+// names. Worktrees which another kosho process is still running a command in
+// are left alone.
+func (kr *KoshoDir) CollectTmpWorktrees() ([]string, error) {
+	worktrees, err := kr.ListWorktrees()
+	if err != nil {
+		return nil, err
+	}
+
+	var collected []string
+	for _, kw := range worktrees {
+		if kw.State == WORKTREE_PRUNABLE || kw.State == WORKTREE_LOCKED {
+			continue
+		}
+		metadata := kr.loadMetadata(kw.Name())
+		if metadata == nil || metadata.TmpPid == 0 || processExists(metadata.TmpPid) {
+			continue
+		}
+		pids, err := kw.RunningPids()
+		if err != nil {
+			return collected, err
+		}
+		if len(pids) > 0 {
+			continue
+		}
+
+		if kw.State == WORKTREE_ORPHAN {
+			// the owner died before git registered the worktree
+			err = os.RemoveAll(kw.WorktreePath())
+			if err == nil {
+				err = kw.RemoveMetadata()
+			}
+		} else {
+			err = kw.Remove(true)
+		}
+		if err != nil {
+			return collected, fmt.Errorf("failed to remove temporary worktree '%s': %w", kw.Name(), err)
+		}
+		collected = append(collected, kw.Name())
+	}
+	return collected, nil
+}
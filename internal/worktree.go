@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -14,23 +16,75 @@ type KoshoWorktree struct {
 	KoshoDir     KoshoDir
 	BranchName   string
 	WorktreeName string
+
+	// DetachedRef is the tag, commit or other ref a worktree with a detached
+	// HEAD was created at. BranchName is empty for these worktrees.
+	DetachedRef string
+
+	// State and StateReason are set by ListWorktrees
+	State       WorktreeState
+	StateReason string
+
+	// pooled worktrees are stored in the warm pool rather than the worktrees
+	// directory and are checked out at a detached HEAD
+	pooled bool
+
+	// path overrides the location of worktrees which are registered with git
+	// outside of the worktrees directory
+	path string
 }
 
-// NewKoshoWorktree creates a new KoshoWorktree instance
+type WorktreeState string
+
+const (
+	// Registered with git and stored in the worktrees directory
+	WORKTREE_OK WorktreeState = "ok"
+
+	// Registered with git but stored outside the worktrees directory
+	WORKTREE_MOVED WorktreeState = "moved"
+
+	// Adopted in place with `kosho adopt`, stored outside the worktrees
+	// directory
+	WORKTREE_ADOPTED WorktreeState = "adopted"
+
+	// Locked with `git worktree lock`
+	WORKTREE_LOCKED WorktreeState = "locked"
+
+	// Registered with git but missing, `git worktree prune` will remove it
+	WORKTREE_PRUNABLE WorktreeState = "prunable"
+
+	// A directory in the worktrees directory which isn't registered with git
+	WORKTREE_ORPHAN WorktreeState = "orphan"
+)
+
+// NewKoshoWorktree creates a new KoshoWorktree instance named after the
+// branch. Use KoshoDir.ResolveWorktree to find the worktree for a branch.
 func NewKoshoWorktree(root KoshoDir, branchName string) *KoshoWorktree {
 	return &KoshoWorktree{
 		KoshoDir:     root,
 		BranchName:   branchName,
-		WorktreeName: sluggify(branchName),
+		WorktreeName: DefaultWorktreeName(branchName),
 	}
 }
 
+// DefaultWorktreeName returns the name of the worktree for a branch unless
+// the name is already taken by another branch
+func DefaultWorktreeName(branchName string) string {
+	return sluggify(branchName)
+}
+
 func (kw *KoshoWorktree) Name() string {
 	return kw.WorktreeName
 }
 
 // WorktreePath returns the full path to the worktree directory
 func (kw *KoshoWorktree) WorktreePath() string {
+	if kw.path != "" {
+		return kw.path
+	}
+	if kw.pooled {
+		return kw.KoshoDir.PoolPath(kw.WorktreeName)
+	}
 	return kw.KoshoDir.WorktreePath(kw.WorktreeName)
 }
 
@@ -43,32 +97,150 @@ func (kw *KoshoWorktree) Exists() (bool, error) {
 	return true, nil
 }
 
-func (kw *KoshoWorktree) CreateWorktree() error {
+// CreateOptions controls how a new worktree is created
+type CreateOptions struct {
+	// Directories to check out using cone mode sparse checkout. The whole
+	// repository is checked out if empty.
+	Sparse []string
+
+	// Commit to create the branch at if it doesn't exist yet, defaults to
+	// the HEAD of the repository
+	From string
+
+	// Uncommitted changes to apply to the new worktree. New branches start
+	// at the commit the changes were captured at, unless From is set.
+	Carry *CarriedChanges
+
+	// Pid of the `kosho tmp` process which owns the worktree. It's recorded
+	// before the worktree is added, so that the worktree is collected even if
+	// the process dies while creating it.
+	TmpPid int
+}
+
+// CreateWorktree creates the worktree, applies the provision rules and any
+// carried changes, removing the worktree if any step after
+// `git worktree add` fails. Carried changes are left in their source.
+func (kw *KoshoWorktree) CreateWorktree(opts CreateOptions) ([]ProvisionResult, error) {
 	worktreePath := kw.WorktreePath()
 
-	args := []string{"worktree", "add"}
-	if !BranchExists(kw.KoshoDir.repoPath, kw.BranchName) {
+	args := []string{"add"}
+	if len(opts.Sparse) > 0 {
+		args = append(args, "--no-checkout")
+	}
+	if kw.DetachedRef != "" {
+		if opts.From != "" {
+			return nil, fmt.Errorf("detached worktrees are created at %s, not %s", kw.DetachedRef, opts.From)
+		}
+		args = append(args, "--detach", worktreePath, kw.DetachedRef)
+	} else if !BranchExists(kw.KoshoDir.repoPath, kw.BranchName) {
+		if opts.From == "" && opts.Carry != nil {
+			opts.From = opts.Carry.head
+		}
 		args = append(args, "-b", kw.BranchName, worktreePath)
+		if opts.From != "" {
+			args = append(args, opts.From)
+		}
+	} else if opts.From != "" {
+		return nil, fmt.Errorf("branch %s already exists, so it can't be created from %s", kw.BranchName, opts.From)
 	} else if kw.BranchName != kw.WorktreeName {
 		args = append(args, worktreePath, kw.BranchName)
 	} else {
 		args = append(args, worktreePath)
 	}
 
-	cmd := exec.Command("git", args...)
+	if opts.TmpPid != 0 {
+		if err := kw.SaveMetadata(&WorktreeMetadata{Branch: kw.BranchName, TmpPid: opts.TmpPid}); err != nil {
+			return nil, err
+		}
+	}
+
+	cmd := worktreeCmd(args...)
 	cmd.Dir = kw.KoshoDir.RepoPath()
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("failed to create worktree: %w\nOutput: %s", err, string(output))
+		err = fmt.Errorf("failed to create worktree: %w\nOutput: %s", err, string(output))
+		if opts.TmpPid != 0 {
+			return nil, errors.Join(err, kw.RemoveMetadata())
+		}
+		return nil, err
+	}
+	var results []ProvisionResult
+	err = relativizeGitLink(worktreePath)
+	if err == nil {
+		results, err = kw.setupWorktree(opts)
+	}
+	if err == nil && opts.Carry != nil {
+		err = opts.Carry.changes.applyTo(worktreePath)
+	}
+	if err != nil {
+		if removeErr := kw.Remove(true); removeErr != nil {
+			return nil, fmt.Errorf("%w (failed to remove worktree: %w)", err, removeErr)
+		}
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (kw *KoshoWorktree) setupWorktree(opts CreateOptions) ([]ProvisionResult, error) {
+	if len(opts.Sparse) > 0 {
+		if err := kw.setSparseCheckout(opts.Sparse); err != nil {
+			return nil, err
+		}
+
+		// the worktree was added with --no-checkout, so populate it now that
+		// the sparse patterns are in place
+		cmd := exec.Command("git", "checkout")
+		cmd.Dir = kw.WorktreePath()
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to check out sparse worktree: %w\nOutput: %s", err, string(output))
+		}
+	}
+
+	// git can't move worktrees containing submodules, so pooled worktrees
+	// initialize them once they are claimed
+	if kw.KoshoDir.config.UseSubmodules() && !kw.pooled {
+		if err := kw.initSubmodules(); err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := kw.Provision()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	metadata := &WorktreeMetadata{Branch: kw.BranchName, Sparse: opts.Sparse, TmpPid: opts.TmpPid}
+	if kw.DetachedRef != "" {
+		metadata.Detached = kw.DetachedRef
+		if metadata.DetachedCommit, err = ResolveCommit(kw.WorktreePath(), "HEAD"); err != nil {
+			return nil, err
+		}
+	}
+	return results, kw.SaveMetadata(metadata)
 }
 
 // Remove the worktree if it's clean, but leaves the branch as is.
 // force will cause the worktree to be removed even if it's dirty
 func (kw *KoshoWorktree) Remove(force bool) error {
+	// git refuses to remove worktrees containing submodules without --force,
+	// so check that the worktree is clean ourselves
+	if !force {
+		hasSubmodules, err := kw.hasInitializedSubmodules()
+		if err != nil {
+			return err
+		}
+		if hasSubmodules {
+			if dirty, err := kw.IsDirty(); err != nil {
+				return err
+			} else if dirty {
+				return fmt.Errorf("worktree '%s' contains modified or untracked files", kw.Name())
+			}
+			force = true
+		}
+	}
+
 	// Build git worktree remove command
 	args := []string{"worktree", "remove", kw.WorktreePath()}
 	if force {
@@ -83,17 +255,28 @@ func (kw *KoshoWorktree) Remove(force bool) error {
 		return fmt.Errorf("failed to remove worktree: %w\nOutput: %s", err, string(output))
 	}
 
-	return nil
+	if err := kw.clearRunning(); err != nil {
+		return err
+	}
+	return kw.RemoveMetadata()
 }
 
-// RunCommand runs a command in the worktree directory
-func (kw *KoshoWorktree) RunCommand(command []string) error {
+// RunCommand runs a command in the worktree directory, or in dir relative to
+// the worktree directory if it is not empty. The worktree is recorded as
+// running a command until it exits.
+func (kw *KoshoWorktree) RunCommand(dir string, command []string) error {
 	if len(command) == 0 {
 		return fmt.Errorf("no command provided")
 	}
 
+	done, err := kw.markRunning()
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	cmd := exec.Command(command[0], command[1:]...)
-	cmd.Dir = kw.WorktreePath()
+	cmd.Dir = filepath.Join(kw.WorktreePath(), dir)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -150,6 +333,17 @@ func (kw *KoshoWorktree) Status() (string, error) {
 		}
 	}
 
+	drifted, uninitialized, err := kw.SubmoduleDrift()
+	if err != nil {
+		return "", err
+	}
+	if drifted != 0 {
+		statusParts = append(statusParts, fmt.Sprintf("submodules drifted %d", drifted))
+	}
+	if uninitialized != 0 {
+		statusParts = append(statusParts, fmt.Sprintf("submodules uninitialized %d", uninitialized))
+	}
+
 	isDirty, err := kw.IsDirty()
 	if err != nil {
 		return "", fmt.Errorf("failed to check if worktree is dirty: %w", err)
@@ -204,6 +398,19 @@ func (kw *KoshoWorktree) GitBranch() (string, error) {
 	return branch, nil
 }
 
+// ShortCommit returns the abbreviated commit hash of the worktree's HEAD
+func (kw *KoshoWorktree) ShortCommit() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
+	cmd.Dir = kw.WorktreePath()
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree commit: %w", err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
 // GetUpstream returns the upstream branch name if one exists
 func (kw *KoshoWorktree) GetUpstream() (string, error) {
 	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "@{u}")
@@ -0,0 +1,22 @@
+package internal
+
+import "testing"
+
+func TestDefaultWorktreeName(t *testing.T) {
+	tests := []struct {
+		branch string
+		want   string
+	}{
+		{"main", "main"},
+		{"feat/login-page", "feat-login-page"},
+		{"Feature/Login_Page", "feature-login-page"},
+		{"--fix//bug--", "fix-bug"},
+		{"v1.2.3", "v1-2-3"},
+		{"café/über", "café-über"},
+	}
+	for _, tt := range tests {
+		if got := DefaultWorktreeName(tt.branch); got != tt.want {
+			t.Errorf("DefaultWorktreeName(%q) = %q, want %q", tt.branch, got, tt.want)
+		}
+	}
+}